@@ -0,0 +1,191 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// LoggerInfo describes a registered logger.
+type LoggerInfo struct {
+	Name      string `json:"name"`
+	Level     string `json:"level"`
+	Formatter string `json:"formatter"`
+	Pattern   string `json:"pattern"`
+}
+
+// AdminStatus is the response of AdminHandler.
+type AdminStatus struct {
+	Config  *Configuration `json:"config"`
+	Loggers []*LoggerInfo  `json:"loggers"`
+}
+
+// AdminHandler is an http.Handler to inspect and change the configuration
+// at runtime.
+//
+// GET lists registered loggers with their effective level, formatter and
+// matching LOGXI pattern. PUT and POST change LOGXI, LOGXI_FORMAT,
+// LOGXI_COLORS, LOGXI_SAMPLE and LOGXI_DEDUP either as a JSON
+// Configuration or as form values named after the environment variables.
+// Empty values are left unchanged. Invalid levels, patterns and rules are
+// rejected with 400 Bad Request and the configuration is left unchanged.
+// LOGXI_SINKS cannot be changed as it opens files and connections.
+//
+// The handler does not authenticate requests. Anyone who can reach it may
+// enable debug logging or change formats, so serve it on an internal
+// address or wrap it with authentication.
+//
+// Example
+// http.Handle("/debug/logxi", log.AdminHandler())
+// curl -XPUT -d LOGXI='*=ERR,models=DBG' localhost:8080/debug/logxi
+func AdminHandler() http.Handler {
+	return http.HandlerFunc(serveAdmin)
+}
+
+// maxAdminBody is the maximum size of a request body of AdminHandler
+const maxAdminBody = 1 << 20
+
+func serveAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "PUT", "POST":
+		r.Body = http.MaxBytesReader(w, r.Body, maxAdminBody)
+		update, err := readAdminConfiguration(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// merge with the current configuration while no other change
+		// is processed
+		pkgMutex.Lock()
+		conf := mergeConfiguration(logxiConfig, update)
+		err = validateConfiguration(conf)
+		if err == nil {
+			applyConfiguration(conf)
+		}
+		pkgMutex.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := json.MarshalIndent(adminStatus(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// readAdminConfiguration reads the values of a request.
+func readAdminConfiguration(r *http.Request) (*Configuration, error) {
+	var update Configuration
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			return nil, fmt.Errorf("Could not decode configuration: %s", err)
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		update.Levels = r.Form.Get("LOGXI")
+		update.Format = r.Form.Get("LOGXI_FORMAT")
+		update.Colors = r.Form.Get("LOGXI_COLORS")
+		update.Sample = r.Form.Get("LOGXI_SAMPLE")
+		update.Dedup = r.Form.Get("LOGXI_DEDUP")
+	}
+	return &update, nil
+}
+
+// mergeConfiguration returns a copy of current with the non-empty values
+// of update.
+func mergeConfiguration(current, update *Configuration) *Configuration {
+	conf := *current
+	if update.Levels != "" {
+		conf.Levels = update.Levels
+	}
+	if update.Format != "" {
+		conf.Format = update.Format
+	}
+	if update.Colors != "" {
+		conf.Colors = update.Colors
+	}
+	if update.Sample != "" {
+		conf.Sample = update.Sample
+	}
+	if update.Dedup != "" {
+		conf.Dedup = update.Dedup
+	}
+	return &conf
+}
+
+// validateConfiguration returns an error if a level, pattern or rule of
+// conf is invalid. The Process* functions would skip or default them.
+func validateConfiguration(conf *Configuration) error {
+	for key, value := range parseKVList(conf.Levels, ",") {
+		pattern := strings.TrimPrefix(key, "-")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid pattern %q in LOGXI: %s", pattern, err)
+		}
+		if value == "" || pattern != key {
+			continue
+		}
+		if _, err := ParseLevel(value); err != nil {
+			return fmt.Errorf("Invalid level %q in LOGXI: %s", value, err)
+		}
+	}
+	if conf.Sample != "off" {
+		for key, value := range parseKVList(conf.Sample, ",") {
+			if _, err := parseSampleRule(key, value); err != nil {
+				return fmt.Errorf("Invalid rule %q in LOGXI_SAMPLE: %s", key+"="+value, err)
+			}
+		}
+	}
+	if conf.Dedup != "off" {
+		for key, value := range parseKVList(conf.Dedup, ",") {
+			if _, err := parseDedupRule(key, value); err != nil {
+				return fmt.Errorf("Invalid rule %q in LOGXI_DEDUP: %s", key+"="+value, err)
+			}
+		}
+	}
+	return nil
+}
+
+// adminStatus returns the configuration and the registered loggers while no
+// other change is processed.
+func adminStatus() *AdminStatus {
+	pkgMutex.Lock()
+	defer pkgMutex.Unlock()
+	conf := *logxiConfig
+	status := &AdminStatus{Config: &conf, Loggers: []*LoggerInfo{}}
+
+	loggers.Lock()
+	defer loggers.Unlock()
+	for name, logger := range loggers.loggers {
+		if name == "__logxi" {
+			continue
+		}
+		pattern, _ := matchLogLevel(name)
+		status.Loggers = append(status.Loggers, &LoggerInfo{
+			Name:      name,
+			Level:     logger.level.get().String(),
+			Formatter: fmt.Sprintf("%T", logger.formatters().formatter),
+			Pattern:   pattern,
+		})
+	}
+	sort.Slice(status.Loggers, func(i, j int) bool {
+		return status.Loggers[i].Name < status.Loggers[j].Name
+	})
+	return status
+}
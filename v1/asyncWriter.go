@@ -0,0 +1,319 @@
+package log
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LevelWriter is implemented by writers which handle entries by level.
+// DefaultLogger formats an entry into a buffer and passes it with its level
+// to WriteLevel. LevelWriters must be safe for concurrent use.
+type LevelWriter interface {
+	io.Writer
+	WriteLevel(level Level, p []byte) (n int, err error)
+}
+
+// OverflowPolicy is what AsyncWriter does when its queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the caller until there is room in the queue.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest drops the oldest queued entry.
+	OverflowDropOldest
+
+	// OverflowDropNewest drops the entry being written.
+	OverflowDropNewest
+
+	// OverflowBlockErrors blocks for entries with level LevelError or more
+	// severe and drops other entries.
+	OverflowBlockErrors
+)
+
+// droppedReportInterval is how often AsyncWriter reports dropped entries
+// while its queue does not drain
+const droppedReportInterval = time.Second
+
+// DroppedKey is the key of the number of entries dropped by AsyncWriter.
+var DroppedKey = "_dropped"
+
+// ErrWriterClosed is returned when writing to a closed AsyncWriter.
+var ErrWriterClosed = errors.New("writer is closed")
+
+// AsyncWriter queues entries on a bounded channel which is drained by a
+// background goroutine, so a slow disk or pipe does not stall callers. The
+// number of dropped entries is written when the queue drains, or every
+// second while it does not, as an entry at LevelWarn formatted
+// with the formatter set with SetFormatter. Without a formatter it is
+// logged as an error with InternalLog so the stream of the writer is not
+// mixed with another format.
+type AsyncWriter struct {
+	writer io.Writer
+	policy OverflowPolicy
+	queue  chan []byte
+	quit   chan struct{}
+	done   chan struct{}
+
+	// sendMu guards closed and is held while sending to queue
+	sendMu sync.RWMutex
+	closed bool
+
+	// mu and idle track the entries which are queued or being written
+	mu      sync.Mutex
+	idle    *sync.Cond
+	pending int
+
+	// writeMu serializes writes and flushes of writer
+	writeMu sync.Mutex
+	dropped uint64
+	// reported is when dropped entries were last reported. writeMu must
+	// be held.
+	reported       time.Time
+	reportInterval time.Duration
+
+	// formatterMu guards formatter which reports dropped entries
+	formatterMu sync.Mutex
+	formatter   Formatter
+}
+
+var asyncWriters = struct {
+	sync.Mutex
+	writers map[*AsyncWriter]bool
+}{writers: map[*AsyncWriter]bool{}}
+
+// NewAsyncWriter creates an AsyncWriter which queues up to size entries
+// for writer.
+//
+// Example
+// writer := log.NewAsyncWriter(file, 1024, log.OverflowBlockErrors)
+// defer writer.Close()
+// logger := log.NewLogger(writer, "server")
+func NewAsyncWriter(writer io.Writer, size int, policy OverflowPolicy) *AsyncWriter {
+	if writer == nil {
+		panic("writer is nil")
+	}
+	if size < 1 {
+		size = 1
+	}
+	aw := &AsyncWriter{
+		writer: writer,
+		policy: policy,
+		queue:  make(chan []byte, size),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+
+		reported:       time.Now(),
+		reportInterval: droppedReportInterval,
+	}
+	aw.idle = sync.NewCond(&aw.mu)
+
+	asyncWriters.Lock()
+	asyncWriters.writers[aw] = true
+	asyncWriters.Unlock()
+
+	go aw.drain()
+	return aw
+}
+
+// Write queues p. Entries written without a level are treated as LevelInfo.
+func (aw *AsyncWriter) Write(p []byte) (int, error) {
+	return aw.WriteLevel(LevelInfo, p)
+}
+
+// WriteLevel queues p which is an entry at level.
+func (aw *AsyncWriter) WriteLevel(level Level, p []byte) (int, error) {
+	aw.sendMu.RLock()
+	defer aw.sendMu.RUnlock()
+	if aw.closed {
+		return 0, ErrWriterClosed
+	}
+
+	// the caller may reuse p
+	b := make([]byte, len(p))
+	copy(b, p)
+	aw.add(1)
+
+	policy := aw.policy
+	if policy == OverflowBlockErrors {
+		if level <= LevelError {
+			policy = OverflowBlock
+		} else {
+			policy = OverflowDropNewest
+		}
+	}
+
+	switch policy {
+	case OverflowDropNewest:
+		select {
+		case aw.queue <- b:
+		default:
+			aw.drop()
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case aw.queue <- b:
+				return len(p), nil
+			default:
+			}
+			select {
+			case <-aw.queue:
+				aw.drop()
+			default:
+			}
+		}
+	default:
+		aw.queue <- b
+	}
+	return len(p), nil
+}
+
+// SetFormatter sets the formatter of the entry which reports dropped
+// entries, usually the formatter of the logger writing to this writer.
+//
+// Example
+// writer := log.NewAsyncWriter(file, 1024, log.OverflowDropNewest)
+// writer.SetFormatter(log.NewJSONFormatter("server"))
+func (aw *AsyncWriter) SetFormatter(formatter Formatter) {
+	aw.formatterMu.Lock()
+	aw.formatter = formatter
+	aw.formatterMu.Unlock()
+}
+
+// queueLen returns the number of queued entries.
+func (aw *AsyncWriter) queueLen() int {
+	return len(aw.queue)
+}
+
+// Dropped returns the number of entries dropped since the last report.
+func (aw *AsyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&aw.dropped)
+}
+
+func (aw *AsyncWriter) add(n int) {
+	aw.mu.Lock()
+	aw.pending += n
+	if aw.pending == 0 {
+		aw.idle.Broadcast()
+	}
+	aw.mu.Unlock()
+}
+
+func (aw *AsyncWriter) drop() {
+	atomic.AddUint64(&aw.dropped, 1)
+	aw.add(-1)
+}
+
+func (aw *AsyncWriter) drain() {
+	defer close(aw.done)
+	for {
+		select {
+		case p := <-aw.queue:
+			aw.writeMu.Lock()
+			aw.writer.Write(p)
+			if len(aw.queue) == 0 || time.Since(aw.reported) >= aw.reportInterval {
+				aw.reportDropped()
+			}
+			aw.writeMu.Unlock()
+			aw.add(-1)
+		case <-aw.quit:
+			return
+		}
+	}
+}
+
+// reportDropped writes the number of dropped entries. writeMu must be held.
+func (aw *AsyncWriter) reportDropped() {
+	aw.reported = time.Now()
+	dropped := atomic.SwapUint64(&aw.dropped, 0)
+	if dropped == 0 {
+		return
+	}
+	aw.formatterMu.Lock()
+	formatter := aw.formatter
+	aw.formatterMu.Unlock()
+	if formatter == nil {
+		InternalLog.Error("Dropped log entries", DroppedKey, dropped)
+		return
+	}
+	formatter.Format(aw.writer, LevelWarn, "Dropped log entries", []interface{}{DroppedKey, dropped})
+}
+
+// wait waits until the queue is drained.
+func (aw *AsyncWriter) wait() {
+	aw.mu.Lock()
+	for aw.pending > 0 {
+		aw.idle.Wait()
+	}
+	aw.mu.Unlock()
+}
+
+// Flush waits until queued entries are written, reports dropped entries
+// and flushes the underlying writer if it implements Flusher.
+func (aw *AsyncWriter) Flush() error {
+	aw.wait()
+
+	aw.writeMu.Lock()
+	defer aw.writeMu.Unlock()
+	aw.reportDropped()
+	if f, ok := aw.writer.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close flushes the writer and stops the background goroutine. Subsequent
+// writes return ErrWriterClosed. The underlying writer is not closed.
+func (aw *AsyncWriter) Close() error {
+	aw.sendMu.Lock()
+	if aw.closed {
+		aw.sendMu.Unlock()
+		return nil
+	}
+	aw.closed = true
+	aw.sendMu.Unlock()
+
+	asyncWriters.Lock()
+	delete(asyncWriters.writers, aw)
+	asyncWriters.Unlock()
+
+	err := aw.Flush()
+	close(aw.quit)
+	<-aw.done
+	return err
+}
+
+// Shutdown writes pending summaries of duplicates, flushes the writers of
+// registered loggers and closes all AsyncWriters. Call it before the
+// process exits.
+func Shutdown() error {
+	err := Flush()
+
+	asyncWriters.Lock()
+	writers := make([]*AsyncWriter, 0, len(asyncWriters.writers))
+	for aw := range asyncWriters.writers {
+		writers = append(writers, aw)
+	}
+	asyncWriters.Unlock()
+
+	for _, aw := range writers {
+		if closeErr := aw.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// asLevelWriter returns the LevelWriter of writer, if any. ConcurrentWriter
+// is unwrapped as LevelWriters are concurrent safe.
+func asLevelWriter(writer io.Writer) (LevelWriter, bool) {
+	if cw, ok := writer.(*ConcurrentWriter); ok {
+		writer = cw.writer
+	}
+	lw, ok := writer.(LevelWriter)
+	return lw, ok
+}
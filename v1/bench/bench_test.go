@@ -2,6 +2,7 @@ package bench
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	L "log"
 	"os"
 	"testing"
@@ -107,6 +108,48 @@ func BenchmarkLogxiComplex(b *testing.B) {
 
 }
 
+// benchmarkFormatter compares the encoding cost of formatters by logging
+// to ioutil.Discard.
+func benchmarkFormatter(b *testing.B, formatter log.Formatter, complex bool) {
+	l := log.NewLogger3(ioutil.Discard, "bench", formatter)
+	l.SetLevel(log.LevelDebug)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if complex {
+			l.Info("info", "key", 1, "obj", testObject)
+		} else {
+			l.Info("info", "key", 1, "key2", "string", "key3", false)
+		}
+	}
+	b.StopTimer()
+}
+
+func BenchmarkFormatterJSON(b *testing.B) {
+	benchmarkFormatter(b, log.NewJSONFormatter("bench"), false)
+}
+
+func BenchmarkFormatterCBOR(b *testing.B) {
+	benchmarkFormatter(b, log.NewCBORFormatter("bench"), false)
+}
+
+func BenchmarkFormatterMsgpack(b *testing.B) {
+	benchmarkFormatter(b, log.NewMsgpackFormatter("bench"), false)
+}
+
+func BenchmarkFormatterJSONComplex(b *testing.B) {
+	benchmarkFormatter(b, log.NewJSONFormatter("bench"), true)
+}
+
+func BenchmarkFormatterCBORComplex(b *testing.B) {
+	benchmarkFormatter(b, log.NewCBORFormatter("bench"), true)
+}
+
+func BenchmarkFormatterMsgpackComplex(b *testing.B) {
+	benchmarkFormatter(b, log.NewMsgpackFormatter("bench"), true)
+}
+
 func BenchmarkLogrus(b *testing.B) {
 	//fmt.Println("")
 	l := logrus.New()
@@ -0,0 +1,420 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// binaryEncoding is a binary encoding of BinaryFormatter and BinaryDecoder.
+type binaryEncoding interface {
+	writeNil(buf *bytes.Buffer)
+	writeBool(buf *bytes.Buffer, v bool)
+	writeInt(buf *bytes.Buffer, v int64)
+	writeUint(buf *bytes.Buffer, v uint64)
+	writeFloat32(buf *bytes.Buffer, v float32)
+	writeFloat64(buf *bytes.Buffer, v float64)
+	writeString(buf *bytes.Buffer, s string)
+	writeBytes(buf *bytes.Buffer, p []byte)
+	writeTime(buf *bytes.Buffer, t time.Time)
+	writeArrayHeader(buf *bytes.Buffer, n int)
+	writeMapHeader(buf *bytes.Buffer, n int)
+	decode(r *bufio.Reader, depth int) (interface{}, error)
+}
+
+// maxValueDepth is the maximum nesting of encoded and decoded values
+const maxValueDepth = 64
+
+// maxBinaryLength is the maximum length of decoded strings, arrays and maps
+const maxBinaryLength = 1 << 26
+
+// maxBinaryPrealloc is the maximum number of elements allocated for a
+// decoded array or map before its elements are read. Lengths come from the
+// input and may be much larger than the input itself.
+const maxBinaryPrealloc = 64
+
+var errBinaryDepth = errors.New("binary value nested too deeply")
+
+// BinaryFormatter formats each entry as a CBOR (RFC 8949) or MessagePack
+// map with the same keys as JSONFormatter. Booleans, integers, floats,
+// []byte and time.Time values, including the time of the entry, keep their
+// types. Slices and maps are arrays and maps, other values are encoded as
+// they would be in JSON. Errors are their message followed by the call
+// stack. Entries are written back to back without a delimiter; read them
+// with BinaryDecoder.
+type BinaryFormatter struct {
+	name     string
+	encoding binaryEncoding
+	// context is the pre-encoded key-value pairs bound with Logger.With
+	context []byte
+	// contextLen is the number of pairs in context
+	contextLen int
+}
+
+// NewCBORFormatter creates a new BinaryFormatter which writes CBOR. Times
+// have microsecond precision.
+//
+// Example
+// logger := log.NewLogger3(file, "app", log.NewCBORFormatter("app"))
+func NewCBORFormatter(name string) *BinaryFormatter {
+	return &BinaryFormatter{name: name, encoding: cborEncoding{}}
+}
+
+// NewMsgpackFormatter creates a new BinaryFormatter which writes
+// MessagePack.
+func NewMsgpackFormatter(name string) *BinaryFormatter {
+	return &BinaryFormatter{name: name, encoding: msgpackEncoding{}}
+}
+
+// WithContext returns a new BinaryFormatter which writes the pre-encoded
+// key-value pairs args with every entry.
+func (bf *BinaryFormatter) WithContext(args []interface{}) Formatter {
+	buf := pool.Get()
+	defer pool.Put(buf)
+	buf.Write(bf.context)
+	fields := argsToFields(args)
+	var stack string
+	if hasError(fields) {
+		stack = string(debug.Stack())
+	}
+	n := bf.setFields(buf, fields, stack)
+	return &BinaryFormatter{
+		name:       bf.name,
+		encoding:   bf.encoding,
+		context:    append([]byte(nil), buf.Bytes()...),
+		contextLen: bf.contextLen + n,
+	}
+}
+
+// WithName returns a new BinaryFormatter for name.
+func (bf *BinaryFormatter) WithName(name string) Formatter {
+	c := *bf
+	c.name = name
+	return &c
+}
+
+// Format formats a log entry.
+func (bf *BinaryFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	bf.FormatEntry(writer, NewEntry(level, bf.name, msg, args))
+}
+
+// FormatEntry formats entry as a map.
+func (bf *BinaryFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	buf := pool.Get()
+	defer pool.Put(buf)
+
+	n := 5 + bf.contextLen + len(entry.Fields)
+	for _, field := range entry.Fields {
+		if _, ok := field.Value.(error); ok {
+			n++
+		}
+	}
+
+	enc := bf.encoding
+	enc.writeMapHeader(buf, n)
+	enc.writeString(buf, KeyMap.Time)
+	enc.writeTime(buf, entry.Time)
+	enc.writeString(buf, KeyMap.PID)
+	enc.writeInt(buf, int64(pid))
+	enc.writeString(buf, KeyMap.Level)
+	enc.writeString(buf, entry.Level.String())
+	enc.writeString(buf, KeyMap.Name)
+	enc.writeString(buf, bf.name)
+	enc.writeString(buf, KeyMap.Message)
+	enc.writeString(buf, entry.Message)
+	buf.Write(bf.context)
+	bf.setFields(buf, entry.Fields, entry.Stack)
+	buf.WriteTo(writer)
+}
+
+// setFields writes fields and returns the number of pairs written. The
+// stack is written after errors.
+func (bf *BinaryFormatter) setFields(buf *bytes.Buffer, fields []Field, stack string) int {
+	n := 0
+	for _, field := range fields {
+		bf.encoding.writeString(buf, field.Key)
+		bf.appendValue(buf, field.Value, 0)
+		n++
+		if _, ok := field.Value.(error); ok {
+			bf.encoding.writeString(buf, KeyMap.CallStack)
+			bf.encoding.writeString(buf, stack)
+			n++
+		}
+	}
+	return n
+}
+
+// appendValue writes val with its type where the encoding has one.
+func (bf *BinaryFormatter) appendValue(buf *bytes.Buffer, val interface{}, depth int) {
+	enc := bf.encoding
+	switch v := val.(type) {
+	case nil:
+		enc.writeNil(buf)
+		return
+	case error:
+		enc.writeString(buf, v.Error())
+		return
+	case string:
+		enc.writeString(buf, v)
+		return
+	case int:
+		enc.writeInt(buf, int64(v))
+		return
+	case bool:
+		enc.writeBool(buf, v)
+		return
+	case float64:
+		enc.writeFloat64(buf, v)
+		return
+	case time.Time:
+		enc.writeTime(buf, v)
+		return
+	case []byte:
+		enc.writeBytes(buf, v)
+		return
+	}
+
+	value := reflect.ValueOf(val)
+	kind := value.Kind()
+	if kind == reflect.Ptr {
+		if value.IsNil() {
+			enc.writeNil(buf)
+			return
+		}
+		value = value.Elem()
+		kind = value.Kind()
+	}
+	switch kind {
+	case reflect.Bool:
+		enc.writeBool(buf, value.Bool())
+		return
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		enc.writeInt(buf, value.Int())
+		return
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		enc.writeUint(buf, value.Uint())
+		return
+	case reflect.Float32:
+		enc.writeFloat32(buf, float32(value.Float()))
+		return
+	case reflect.Float64:
+		enc.writeFloat64(buf, value.Float())
+		return
+	}
+
+	if stringer, ok := val.(fmt.Stringer); ok {
+		enc.writeString(buf, stringer.String())
+		return
+	}
+	if depth >= maxValueDepth {
+		enc.writeString(buf, fmt.Sprintf("%v", val))
+		return
+	}
+
+	switch kind {
+	case reflect.String:
+		enc.writeString(buf, value.String())
+	case reflect.Slice, reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 && kind == reflect.Slice {
+			enc.writeBytes(buf, value.Bytes())
+			return
+		}
+		enc.writeArrayHeader(buf, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			bf.appendValue(buf, value.Index(i).Interface(), depth+1)
+		}
+	case reflect.Map:
+		keys := value.MapKeys()
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = fmt.Sprintf("%v", key.Interface())
+		}
+		sort.Sort(&mapKeys{names, keys})
+		enc.writeMapHeader(buf, len(keys))
+		for i, key := range keys {
+			enc.writeString(buf, names[i])
+			bf.appendValue(buf, value.MapIndex(key).Interface(), depth+1)
+		}
+	default:
+		bf.appendJSON(buf, val, depth)
+	}
+}
+
+// appendJSON writes val as it is marshaled by encoding/json, eg structs as
+// maps of their exported fields.
+func (bf *BinaryFormatter) appendJSON(buf *bytes.Buffer, val interface{}, depth int) {
+	v, err := toJSONValue(val)
+	if err != nil {
+		InternalLog.Error("Could not json.Marshal value: ", "formatter", "BinaryFormatter", "err", err.Error())
+		bf.encoding.writeString(buf, fmt.Sprintf("%#v", val))
+		return
+	}
+	bf.appendJSONValue(buf, v, depth)
+}
+
+// toJSONValue returns val marshaled and unmarshaled by encoding/json, with
+// numbers as json.Number.
+func toJSONValue(val interface{}) (interface{}, error) {
+	b, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	err = dec.Decode(&v)
+	return v, err
+}
+
+// appendJSONValue writes a value decoded by encoding/json.
+func (bf *BinaryFormatter) appendJSONValue(buf *bytes.Buffer, val interface{}, depth int) {
+	switch v := val.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			bf.encoding.writeInt(buf, i)
+		} else if f, err := v.Float64(); err == nil {
+			bf.encoding.writeFloat64(buf, f)
+		} else {
+			bf.encoding.writeString(buf, string(v))
+		}
+	case []interface{}:
+		bf.encoding.writeArrayHeader(buf, len(v))
+		for _, e := range v {
+			bf.appendJSONValue(buf, e, depth+1)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		bf.encoding.writeMapHeader(buf, len(keys))
+		for _, key := range keys {
+			bf.encoding.writeString(buf, key)
+			bf.appendJSONValue(buf, v[key], depth+1)
+		}
+	default:
+		bf.appendValue(buf, v, depth+1)
+	}
+}
+
+// mapKeys sorts the keys of a map by their names.
+type mapKeys struct {
+	names []string
+	keys  []reflect.Value
+}
+
+func (mk *mapKeys) Len() int           { return len(mk.names) }
+func (mk *mapKeys) Less(i, j int) bool { return mk.names[i] < mk.names[j] }
+func (mk *mapKeys) Swap(i, j int) {
+	mk.names[i], mk.names[j] = mk.names[j], mk.names[i]
+	mk.keys[i], mk.keys[j] = mk.keys[j], mk.keys[i]
+}
+
+// BinaryDecoder reads entries written by BinaryFormatter, eg to filter
+// them or convert them to JSON.
+type BinaryDecoder struct {
+	r        *bufio.Reader
+	encoding binaryEncoding
+}
+
+// NewCBORDecoder creates a new BinaryDecoder which reads CBOR entries
+// from r.
+//
+// Example
+// dec := log.NewCBORDecoder(os.Stdin)
+// for {
+//     entry, err := dec.Decode()
+//     if err == io.EOF {
+//         break
+//     }
+//     ...
+// }
+func NewCBORDecoder(r io.Reader) *BinaryDecoder {
+	return &BinaryDecoder{r: bufio.NewReader(r), encoding: cborEncoding{}}
+}
+
+// NewMsgpackDecoder creates a new BinaryDecoder which reads MessagePack
+// entries from r.
+func NewMsgpackDecoder(r io.Reader) *BinaryDecoder {
+	return &BinaryDecoder{r: bufio.NewReader(r), encoding: msgpackEncoding{}}
+}
+
+// Decode reads the next entry. It returns io.EOF at the end of the stream.
+// Integers are int64, or uint64 if larger than math.MaxInt64, floats are
+// float64, times are time.Time, byte strings are []byte, arrays are
+// []interface{} and maps are map[string]interface{}.
+func (bd *BinaryDecoder) Decode() (map[string]interface{}, error) {
+	if _, err := bd.r.Peek(1); err != nil {
+		return nil, err
+	}
+	v, err := bd.encoding.decode(bd.r, 0)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("entry is %T, not a map", v)
+	}
+	return entry, nil
+}
+
+// readFull reads len(p) bytes.
+func readFull(r *bufio.Reader, p []byte) (int, error) {
+	n, err := io.ReadFull(r, p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// checkBinaryLength returns an error if a decoded length is too large.
+func checkBinaryLength(n uint64) error {
+	if n > maxBinaryLength {
+		return fmt.Errorf("binary length %d is more than %d", n, maxBinaryLength)
+	}
+	return nil
+}
+
+// preallocLength returns the capacity to allocate for an array or map of
+// length n.
+func preallocLength(n uint64) int {
+	if n > maxBinaryPrealloc {
+		return maxBinaryPrealloc
+	}
+	return int(n)
+}
+
+// readBinaryLength reads a string of n bytes.
+func readBinaryLength(r *bufio.Reader, n uint64) ([]byte, error) {
+	if err := checkBinaryLength(n); err != nil {
+		return nil, err
+	}
+	p := make([]byte, n)
+	_, err := readFull(r, p)
+	return p, err
+}
+
+// binaryKey returns a decoded map key as a string.
+func binaryKey(k interface{}) string {
+	switch v := k.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprintf("%v", k)
+}
@@ -247,15 +247,9 @@ func trimDebugStack(stack string) string {
 	return buf.String()
 }
 
-func parseLogxiStack(entry map[string]interface{}, skip int, ignoreRuntime bool) []*frameInfo {
-	kv := entry[KeyMap.CallStack]
-	if kv == nil {
+func parseLogxiStack(stack string, skip int, ignoreRuntime bool) []*frameInfo {
+	if stack == "" {
 		return nil
 	}
-
-	var frames []*frameInfo
-	if stack, ok := kv.(string); ok {
-		frames = parseDebugStack(stack, skip, ignoreRuntime)
-	}
-	return frames
+	return parseDebugStack(stack, skip, ignoreRuntime)
 }
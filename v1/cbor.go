@@ -0,0 +1,328 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// cborIndefinite is the additional information of indefinite lengths
+const cborIndefinite = 31
+
+// cborBreak ends indefinite length items
+const cborBreak = 0xff
+
+// cborEncoding encodes and decodes CBOR (RFC 8949). Times are tag 1 epoch
+// seconds with microsecond precision.
+type cborEncoding struct{}
+
+// writeHead writes the initial byte of major type and the argument n in the
+// fewest bytes.
+func (cborEncoding) writeHead(buf *bytes.Buffer, major byte, n uint64) {
+	var b [9]byte
+	b[0] = major << 5
+	switch {
+	case n < 24:
+		b[0] |= byte(n)
+		buf.WriteByte(b[0])
+	case n <= math.MaxUint8:
+		b[0] |= 24
+		b[1] = byte(n)
+		buf.Write(b[:2])
+	case n <= math.MaxUint16:
+		b[0] |= 25
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		buf.Write(b[:3])
+	case n <= math.MaxUint32:
+		b[0] |= 26
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		buf.Write(b[:5])
+	default:
+		b[0] |= 27
+		binary.BigEndian.PutUint64(b[1:], n)
+		buf.Write(b[:9])
+	}
+}
+
+func (cborEncoding) writeNil(buf *bytes.Buffer) {
+	buf.WriteByte(cborSimple<<5 | 22)
+}
+
+func (cborEncoding) writeBool(buf *bytes.Buffer, v bool) {
+	if v {
+		buf.WriteByte(cborSimple<<5 | 21)
+	} else {
+		buf.WriteByte(cborSimple<<5 | 20)
+	}
+}
+
+func (ce cborEncoding) writeInt(buf *bytes.Buffer, v int64) {
+	if v < 0 {
+		ce.writeHead(buf, cborNegInt, uint64(^v))
+		return
+	}
+	ce.writeHead(buf, cborUint, uint64(v))
+}
+
+func (ce cborEncoding) writeUint(buf *bytes.Buffer, v uint64) {
+	ce.writeHead(buf, cborUint, v)
+}
+
+func (cborEncoding) writeFloat32(buf *bytes.Buffer, v float32) {
+	var b [5]byte
+	b[0] = cborSimple<<5 | 26
+	binary.BigEndian.PutUint32(b[1:], math.Float32bits(v))
+	buf.Write(b[:])
+}
+
+func (cborEncoding) writeFloat64(buf *bytes.Buffer, v float64) {
+	var b [9]byte
+	b[0] = cborSimple<<5 | 27
+	binary.BigEndian.PutUint64(b[1:], math.Float64bits(v))
+	buf.Write(b[:])
+}
+
+func (ce cborEncoding) writeString(buf *bytes.Buffer, s string) {
+	ce.writeHead(buf, cborText, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func (ce cborEncoding) writeBytes(buf *bytes.Buffer, p []byte) {
+	ce.writeHead(buf, cborBytes, uint64(len(p)))
+	buf.Write(p)
+}
+
+// writeTime writes tag 1 with integer seconds, or float seconds if t has
+// fractional seconds.
+func (ce cborEncoding) writeTime(buf *bytes.Buffer, t time.Time) {
+	ce.writeHead(buf, cborTag, 1)
+	if t.Nanosecond() == 0 {
+		ce.writeInt(buf, t.Unix())
+		return
+	}
+	ce.writeFloat64(buf, float64(t.Unix())+float64(t.Nanosecond())/1e9)
+}
+
+func (ce cborEncoding) writeArrayHeader(buf *bytes.Buffer, n int) {
+	ce.writeHead(buf, cborArray, uint64(n))
+}
+
+func (ce cborEncoding) writeMapHeader(buf *bytes.Buffer, n int) {
+	ce.writeHead(buf, cborMap, uint64(n))
+}
+
+// readHead reads an initial byte and its argument. indefinite is true for
+// indefinite lengths.
+func (cborEncoding) readHead(r *bufio.Reader) (major byte, info byte, n uint64, err error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b>>5, b&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		var p [8]byte
+		size := 1 << (info - 24)
+		if _, err = readFull(r, p[8-size:]); err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, binary.BigEndian.Uint64(p[:]), nil
+	case info == cborIndefinite && major != cborUint && major != cborNegInt && major != cborTag:
+		return major, info, 0, nil
+	}
+	return 0, 0, 0, fmt.Errorf("cbor: invalid additional information %d", info)
+}
+
+// decode reads a value. Integers are int64, or uint64 if larger than
+// math.MaxInt64, floats are float64, times are time.Time, byte strings are
+// []byte, arrays are []interface{} and maps are map[string]interface{}.
+func (ce cborEncoding) decode(r *bufio.Reader, depth int) (interface{}, error) {
+	if depth > maxValueDepth {
+		return nil, errBinaryDepth
+	}
+	major, info, n, err := ce.readHead(r)
+	if err != nil {
+		return nil, err
+	}
+	indefinite := info == cborIndefinite
+
+	switch major {
+	case cborUint:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: negative integer -1-%d overflows int64", n)
+		}
+		return -1 - int64(n), nil
+
+	case cborBytes, cborText:
+		var p []byte
+		if indefinite {
+			p, err = ce.readChunks(r, major)
+		} else {
+			p, err = readBinaryLength(r, n)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if major == cborText {
+			return string(p), nil
+		}
+		return p, nil
+
+	case cborArray:
+		if err = checkBinaryLength(n); err != nil {
+			return nil, err
+		}
+		a := make([]interface{}, 0, preallocLength(n))
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite && ce.isBreak(r) {
+				break
+			}
+			v, err := ce.decode(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+
+	case cborMap:
+		if err = checkBinaryLength(n); err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, preallocLength(n))
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite && ce.isBreak(r) {
+				break
+			}
+			k, err := ce.decode(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := ce.decode(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[binaryKey(k)] = v
+		}
+		return m, nil
+
+	case cborTag:
+		v, err := ce.decode(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return cborTagged(n, v)
+	}
+
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return float64(halfToFloat32(uint16(n))), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(n))), nil
+	case 27:
+		return math.Float64frombits(n), nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", n)
+}
+
+// isBreak consumes the break byte of an indefinite length item.
+func (cborEncoding) isBreak(r *bufio.Reader) bool {
+	p, err := r.Peek(1)
+	if err == nil && p[0] == cborBreak {
+		r.ReadByte()
+		return true
+	}
+	return false
+}
+
+// readChunks reads the definite length chunks of an indefinite length byte
+// or text string.
+func (ce cborEncoding) readChunks(r *bufio.Reader, major byte) ([]byte, error) {
+	var p []byte
+	for !ce.isBreak(r) {
+		m, info, n, err := ce.readHead(r)
+		if err != nil {
+			return nil, err
+		}
+		if m != major || info == cborIndefinite {
+			return nil, fmt.Errorf("cbor: invalid chunk of major type %d", m)
+		}
+		chunk, err := readBinaryLength(r, n)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, chunk...)
+	}
+	return p, nil
+}
+
+// cborTagged returns the value of tag 0 (RFC 3339) and tag 1 (epoch) times,
+// or v for other tags.
+func cborTagged(tag uint64, v interface{}) (interface{}, error) {
+	switch tag {
+	case 0:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("cbor: tag 0 of %T", v)
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case 1:
+		switch secs := v.(type) {
+		case int64:
+			return time.Unix(secs, 0), nil
+		case float64:
+			whole, frac := math.Modf(secs)
+			return time.Unix(int64(whole), int64(frac*1e9)).Round(time.Microsecond), nil
+		}
+		return nil, fmt.Errorf("cbor: tag 1 of %T", v)
+	}
+	return v, nil
+}
+
+// halfToFloat32 converts an IEEE 754 half precision float.
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		// zero or subnormal
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | frac<<13)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"os"
@@ -20,12 +21,39 @@ func sendExternal(obj map[string]interface{}) {
 	)
 }
 
+// decoder reads log entries
+type decoder interface {
+	Decode() (map[string]interface{}, error)
+}
+
+type jsonDecoder struct {
+	dec *json.Decoder
+}
+
+func (jd *jsonDecoder) Decode() (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	err := jd.dec.Decode(&obj)
+	return obj, err
+}
+
 func main() {
+	format := flag.String("format", log.FormatJSON, "format of entries: JSON, cbor or msgpack")
+	flag.Parse()
+
 	r := bufio.NewReader(os.Stdin)
-	dec := json.NewDecoder(r)
+	var dec decoder
+	switch *format {
+	case log.FormatCBOR:
+		dec = log.NewCBORDecoder(r)
+	case log.FormatMsgpack:
+		dec = log.NewMsgpackDecoder(r)
+	default:
+		dec = &jsonDecoder{json.NewDecoder(r)}
+	}
+
 	for {
-		var obj map[string]interface{}
-		if err := dec.Decode(&obj); err == io.EOF {
+		obj, err := dec.Decode()
+		if err == io.EOF {
 			break
 		} else if err != nil {
 			log.InternalLog.Fatal("Could not decode", "err", err)
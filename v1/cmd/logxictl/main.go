@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mgutz/logxi/v1"
+)
+
+var url = flag.String("url", "http://localhost:8080/debug/logxi", "URL of log.AdminHandler")
+var levels = flag.String("levels", "", "set LOGXI, eg '*=ERR,models=DBG'")
+var format = flag.String("format", "", "set LOGXI_FORMAT, eg 'JSON'")
+var colors = flag.String("colors", "", "set LOGXI_COLORS")
+var sample = flag.String("sample", "", "set LOGXI_SAMPLE, eg '*=100/10' or 'off'")
+var dedup = flag.String("dedup", "", "set LOGXI_DEDUP, eg '*=30s' or 'off'")
+
+func request() (*http.Response, error) {
+	if *levels == "" && *format == "" && *colors == "" && *sample == "" && *dedup == "" {
+		return http.Get(*url)
+	}
+
+	conf := &log.Configuration{Levels: *levels, Format: *format, Colors: *colors, Sample: *sample, Dedup: *dedup}
+	b, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	return http.Post(*url, "application/json", bytes.NewReader(b))
+}
+
+func main() {
+	flag.Parse()
+
+	res, err := request()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(res.Body)
+		fmt.Fprintf(os.Stderr, "%s: %s", res.Status, b)
+		os.Exit(1)
+	}
+
+	var status log.AdminStatus
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not decode response:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("LOGXI=%s\nLOGXI_FORMAT=%s\nLOGXI_COLORS=%s\nLOGXI_SAMPLE=%s\nLOGXI_DEDUP=%s\n\n",
+		status.Config.Levels, status.Config.Format, status.Config.Colors, status.Config.Sample, status.Config.Dedup)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tLEVEL\tPATTERN\tFORMATTER")
+	for _, l := range status.Loggers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", l.Name, l.Level, l.Pattern, l.Formatter)
+	}
+	w.Flush()
+}
@@ -0,0 +1,112 @@
+package log
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// defaultColumns returns the columns of ColumnFormatter when none are set.
+func defaultColumns() []string {
+	if columns := settings().columns; len(columns) > 0 {
+		return columns
+	}
+	return []string{KeyMap.Time, KeyMap.Level, KeyMap.Name, KeyMap.Message}
+}
+
+// ColumnFormatter formats entries as rows of CSV or TSV with a fixed set of
+// columns, eg for spreadsheets and bulk loaders. Columns named by KeyMap
+// are the time, pid, name, level, message and call stack of the entry. Other
+// columns are the value of the last field with that key, or empty.
+type ColumnFormatter struct {
+	name string
+	// Columns are the keys of the columns of each row.
+	Columns []string
+	// Comma is the column separator. Values are quoted as CSV (RFC 4180)
+	// as needed, except for TSV ('\t') where backslashes, tabs and line
+	// breaks in values are escaped as \\, \t, \n and \r instead.
+	Comma rune
+}
+
+// NewCSVFormatter creates a new ColumnFormatter which writes CSV rows of
+// columns. Without columns it writes the columns of LOGXI_FORMAT, or the
+// time, level, name and message.
+//
+// Example
+// formatter := log.NewCSVFormatter("app", log.KeyMap.Time, log.KeyMap.Message, "user")
+// logger := log.NewLogger3(file, "app", formatter)
+func NewCSVFormatter(name string, columns ...string) *ColumnFormatter {
+	if len(columns) == 0 {
+		columns = defaultColumns()
+	}
+	return &ColumnFormatter{name: name, Columns: columns, Comma: ','}
+}
+
+// NewTSVFormatter creates a new ColumnFormatter which writes TSV rows of
+// columns. Without columns it writes the columns of LOGXI_FORMAT, or the
+// time, level, name and message.
+func NewTSVFormatter(name string, columns ...string) *ColumnFormatter {
+	cf := NewCSVFormatter(name, columns...)
+	cf.Comma = '\t'
+	return cf
+}
+
+// WithName returns a copy of the formatter for name.
+func (cf *ColumnFormatter) WithName(name string) Formatter {
+	c := *cf
+	c.name = name
+	return &c
+}
+
+// Format formats a log entry as a row.
+func (cf *ColumnFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	cf.FormatEntry(writer, NewEntry(level, cf.name, msg, args))
+}
+
+// FormatEntry formats entry as a row terminated by a newline.
+func (cf *ColumnFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	buf := pool.Get()
+	defer pool.Put(buf)
+
+	record := make([]string, len(cf.Columns))
+	for i, column := range cf.Columns {
+		record[i] = cf.value(entry, column)
+	}
+
+	if cf.Comma == '\t' {
+		for i, value := range record {
+			if i > 0 {
+				buf.WriteByte('\t')
+			}
+			writeEscapedTSV(buf, value)
+		}
+		buf.WriteByte('\n')
+	} else {
+		w := csv.NewWriter(buf)
+		w.Comma = cf.Comma
+		w.Write(record)
+		w.Flush()
+	}
+	buf.WriteTo(writer)
+}
+
+// value returns the value of column in entry.
+func (cf *ColumnFormatter) value(entry *Entry, column string) string {
+	switch column {
+	case KeyMap.Time:
+		return entry.Time.Format(settings().timeFormat)
+	case KeyMap.PID:
+		return pidStr
+	case KeyMap.Name:
+		return cf.name
+	case KeyMap.Level:
+		return entry.Level.String()
+	case KeyMap.Message:
+		return entry.Message
+	case KeyMap.CallStack:
+		return entry.Stack
+	}
+	if value, ok := entry.Value(column); ok {
+		return valueString(value)
+	}
+	return ""
+}
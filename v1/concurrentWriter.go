@@ -23,3 +23,13 @@ func (cw *ConcurrentWriter) Write(p []byte) (n int, err error) {
 	// doesn't look at the returned number of bytes returned
 	return cw.writer.Write(p)
 }
+
+// Flush flushes the wrapped writer if it implements Flusher.
+func (cw *ConcurrentWriter) Flush() error {
+	if f, ok := cw.writer.(Flusher); ok {
+		cw.Lock()
+		defer cw.Unlock()
+		return f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadConfigFile reads a configuration file. Values which are not in the
+// file default to the LOGXI* environment variables.
+//
+// A file ending in ".json" or starting with "{" is decoded as JSON
+//
+//     {"levels": "*=ERR,models=DBG", "format": "JSON"}
+//
+// Otherwise it is read as a simple INI/TOML-like file. Keys may be the JSON
+// names or the environment variable names. Sections and comments starting
+// with "#" or ";" are ignored.
+//
+//     [logxi]
+//     LOGXI = "*=ERR,models=DBG"
+//     LOGXI_FORMAT = JSON
+func LoadConfigFile(path string) (*Configuration, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := readFromEnviron()
+	var fileConf *Configuration
+	trimmed := bytes.TrimSpace(b)
+	if strings.EqualFold(filepath.Ext(path), ".json") || bytes.HasPrefix(trimmed, []byte("{")) {
+		fileConf = &Configuration{}
+		err = json.Unmarshal(trimmed, fileConf)
+	} else {
+		fileConf, err = parseINIConfig(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s: %s", path, err)
+	}
+
+	if fileConf.Levels != "" {
+		conf.Levels = fileConf.Levels
+	}
+	if fileConf.Format != "" {
+		conf.Format = fileConf.Format
+	}
+	if fileConf.Colors != "" {
+		conf.Colors = fileConf.Colors
+	}
+	if fileConf.Sample != "" {
+		conf.Sample = fileConf.Sample
+	}
+	if fileConf.Dedup != "" {
+		conf.Dedup = fileConf.Dedup
+	}
+	if fileConf.Sinks != "" {
+		conf.Sinks = fileConf.Sinks
+	}
+	return conf, nil
+}
+
+func parseINIConfig(b []byte) (*Configuration, error) {
+	conf := &Configuration{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' || line[0] == '[' {
+			continue
+		}
+
+		idx := strings.Index(line, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineno)
+		}
+		key := strings.TrimSpace(line[:idx])
+		value, err := unquoteINIValue(strings.TrimSpace(line[idx+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+
+		switch strings.ToLower(key) {
+		case "levels", "logxi":
+			conf.Levels = value
+		case "format", "logxi_format":
+			conf.Format = value
+		case "colors", "logxi_colors":
+			conf.Colors = value
+		case "sample", "logxi_sample":
+			conf.Sample = value
+		case "dedup", "logxi_dedup":
+			conf.Dedup = value
+		case "sinks", "logxi_sinks":
+			conf.Sinks = value
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", lineno, key)
+		}
+	}
+	return conf, scanner.Err()
+}
+
+func unquoteINIValue(s string) (string, error) {
+	if len(s) >= 2 {
+		switch s[0] {
+		case '"':
+			return strconv.Unquote(s)
+		case '\'':
+			if s[len(s)-1] != '\'' {
+				return "", fmt.Errorf("unterminated string %s", s)
+			}
+			return s[1 : len(s)-1], nil
+		}
+	}
+	return s, nil
+}
+
+// WatchConfigFile subscribes to a FileSource which polls path every
+// interval and on SIGHUP. Updates of Kubernetes ConfigMaps, which swap
+// symlinks, are detected as the file is stat'ed through links.
+func WatchConfigFile(path string, interval time.Duration) (*Subscription, error) {
+	return Subscribe(NewFileSource(path, interval))
+}
@@ -0,0 +1,360 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ConfigSource provides the configuration from the environment, files or
+// external services like consul, etcd.
+type ConfigSource interface {
+	// Get returns the current configuration.
+	Get() (*Configuration, error)
+
+	// Watch sends each changed configuration until stop is closed, then
+	// closes the returned channel.
+	Watch(stop <-chan struct{}) <-chan *Configuration
+}
+
+// Subscription processes configurations of a ConfigSource until stopped.
+type Subscription struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Subscribe processes the configuration of source with ProcessEnv then
+// reprocesses every change.
+func Subscribe(source ConfigSource) (*Subscription, error) {
+	conf, err := source.Get()
+	if err != nil {
+		return nil, err
+	}
+	ProcessEnv(conf)
+
+	sub := &Subscription{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	changes := source.Watch(sub.stop)
+	go func() {
+		defer close(sub.done)
+		for conf := range changes {
+			ProcessEnv(conf)
+		}
+	}()
+	return sub, nil
+}
+
+// Stop stops processing changes.
+func (sub *Subscription) Stop() {
+	close(sub.stop)
+	<-sub.done
+}
+
+// sendConfig sends conf unless stop is closed first.
+func sendConfig(ch chan<- *Configuration, conf *Configuration, stop <-chan struct{}) bool {
+	select {
+	case ch <- conf:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// EnvSource reads the LOGXI* environment variables. The environment does
+// not change so Watch never sends.
+type EnvSource struct{}
+
+// NewEnvSource creates a new EnvSource.
+func NewEnvSource() *EnvSource {
+	return &EnvSource{}
+}
+
+// Get returns the configuration of the environment.
+func (es *EnvSource) Get() (*Configuration, error) {
+	return readFromEnviron(), nil
+}
+
+// Watch closes the returned channel when stop is closed.
+func (es *EnvSource) Watch(stop <-chan struct{}) <-chan *Configuration {
+	ch := make(chan *Configuration)
+	go func() {
+		<-stop
+		close(ch)
+	}()
+	return ch
+}
+
+// FileSource reads a configuration file with LoadConfigFile.
+type FileSource struct {
+	path     string
+	interval time.Duration
+}
+
+// NewFileSource creates a new FileSource which polls path every interval
+// for changes. The file is also reloaded when the process receives SIGHUP.
+func NewFileSource(path string, interval time.Duration) *FileSource {
+	return &FileSource{path: path, interval: interval}
+}
+
+// Get loads the configuration file.
+func (fs *FileSource) Get() (*Configuration, error) {
+	return LoadConfigFile(fs.path)
+}
+
+// Watch sends the configuration whenever the modification time or size of
+// the file changes or on SIGHUP.
+func (fs *FileSource) Watch(stop <-chan struct{}) <-chan *Configuration {
+	ch := make(chan *Configuration)
+
+	var modTime time.Time
+	var size int64
+	var changed = func() bool {
+		fi, err := os.Stat(fs.path)
+		if err != nil {
+			return false
+		}
+		if fi.ModTime().Equal(modTime) && fi.Size() == size {
+			return false
+		}
+		modTime = fi.ModTime()
+		size = fi.Size()
+		return true
+	}
+	changed()
+
+	go func() {
+		defer close(ch)
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		ticker := time.NewTicker(fs.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-hup:
+				changed()
+			case <-ticker.C:
+				if !changed() {
+					continue
+				}
+			}
+
+			conf, err := fs.Get()
+			if err != nil {
+				// a bad file is reported once, not on every poll
+				InternalLog.Error("Could not reload configuration file", "path", fs.path, "err", err)
+				continue
+			}
+			if !sendConfig(ch, conf, stop) {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// HTTPSource reads configuration from an HTTP key-value store like consul or
+// etcd. The keys "levels", "format", "colors", "sample", "dedup" and
+// "sinks" replace "{key}" in the URL template, eg
+// "http://127.0.0.1:8500/v1/kv/logxi/{key}?raw". Keys which are not found
+// default to the LOGXI* environment variables.
+type HTTPSource struct {
+	// URL is the URL template of a key.
+	URL string
+	// Interval is how often keys are polled for changes.
+	Interval time.Duration
+	// Client is the HTTP client used to get keys.
+	Client *http.Client
+	// Decode extracts the value of a key from a response body. Defaults to
+	// the trimmed body. Use DecodeEtcdValue for etcd.
+	Decode func(body []byte) (string, error)
+
+	mu   sync.Mutex
+	last *Configuration
+}
+
+// NewHTTPSource creates a new HTTPSource which polls url every interval.
+func NewHTTPSource(url string, interval time.Duration) *HTTPSource {
+	return &HTTPSource{
+		URL:      url,
+		Interval: interval,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		Decode: func(body []byte) (string, error) {
+			return strings.TrimSpace(string(body)), nil
+		},
+	}
+}
+
+// DecodeEtcdValue decodes the value of an etcd v2 key response.
+func DecodeEtcdValue(body []byte) (string, error) {
+	var res struct {
+		Node struct {
+			Value string `json:"value"`
+		} `json:"node"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", err
+	}
+	return res.Node.Value, nil
+}
+
+func (hs *HTTPSource) getKey(key string) (string, error) {
+	url := strings.Replace(hs.URL, "{key}", key, -1)
+	res, err := hs.Client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	return hs.Decode(body)
+}
+
+// Get gets the keys of the configuration.
+func (hs *HTTPSource) Get() (*Configuration, error) {
+	conf := readFromEnviron()
+	fields := []struct {
+		key   string
+		value *string
+	}{
+		{"levels", &conf.Levels},
+		{"format", &conf.Format},
+		{"colors", &conf.Colors},
+		{"sample", &conf.Sample},
+		{"dedup", &conf.Dedup},
+		{"sinks", &conf.Sinks},
+	}
+	for _, field := range fields {
+		value, err := hs.getKey(field.key)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			*field.value = value
+		}
+	}
+
+	hs.mu.Lock()
+	hs.last = conf
+	hs.mu.Unlock()
+	return conf, nil
+}
+
+// Watch polls the keys and sends the configuration when it differs from
+// the last one returned by Get.
+func (hs *HTTPSource) Watch(stop <-chan struct{}) <-chan *Configuration {
+	hs.mu.Lock()
+	last := hs.last
+	hs.mu.Unlock()
+
+	ch := make(chan *Configuration)
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(hs.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			conf, err := hs.Get()
+			if err != nil {
+				InternalLog.Error("Could not get configuration", "url", hs.URL, "err", err)
+				continue
+			}
+			if last != nil && *conf == *last {
+				continue
+			}
+			last = conf
+			if !sendConfig(ch, conf, stop) {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// MemorySource is a ConfigSource set in code. It is useful for tests.
+type MemorySource struct {
+	sync.Mutex
+	conf     *Configuration
+	watchers []*memoryWatcher
+}
+
+type memoryWatcher struct {
+	ch   chan *Configuration
+	stop <-chan struct{}
+}
+
+// NewMemorySource creates a new MemorySource with an initial configuration.
+func NewMemorySource(conf *Configuration) *MemorySource {
+	return &MemorySource{conf: conf}
+}
+
+// Get returns the current configuration.
+func (ms *MemorySource) Get() (*Configuration, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	conf := *ms.conf
+	return &conf, nil
+}
+
+// Set sets the configuration and sends it to watchers.
+func (ms *MemorySource) Set(conf *Configuration) {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.conf = conf
+	for _, w := range ms.watchers {
+		c := *conf
+		sendConfig(w.ch, &c, w.stop)
+	}
+}
+
+// Watch sends configurations set with Set.
+func (ms *MemorySource) Watch(stop <-chan struct{}) <-chan *Configuration {
+	w := &memoryWatcher{ch: make(chan *Configuration), stop: stop}
+	ms.Lock()
+	ms.watchers = append(ms.watchers, w)
+	ms.Unlock()
+
+	go func() {
+		<-stop
+		ms.Lock()
+		defer ms.Unlock()
+		for i, w2 := range ms.watchers {
+			if w2 == w {
+				ms.watchers = append(ms.watchers[:i], ms.watchers[i+1:]...)
+				break
+			}
+		}
+		close(w.ch)
+	}()
+	return w.ch
+}
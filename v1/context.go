@@ -0,0 +1,191 @@
+package log
+
+import (
+	"context"
+	"sync"
+)
+
+type contextKey int
+
+const (
+	loggerContextKey contextKey = iota
+	levelContextKey
+)
+
+// ContextLogger is a Logger with context.Context aware methods. Values of
+// keys registered with RegisterContextKey are logged with every entry and
+// the level may be overridden per context with NewLevelContext.
+type ContextLogger interface {
+	Logger
+	TraceCtx(ctx context.Context, msg string, args ...interface{})
+	DebugCtx(ctx context.Context, msg string, args ...interface{})
+	InfoCtx(ctx context.Context, msg string, args ...interface{})
+	NoticeCtx(ctx context.Context, msg string, args ...interface{})
+	WarnCtx(ctx context.Context, msg string, args ...interface{}) error
+	ErrorCtx(ctx context.Context, msg string, args ...interface{}) error
+	CriticalCtx(ctx context.Context, msg string, args ...interface{}) error
+	AlertCtx(ctx context.Context, msg string, args ...interface{}) error
+	EmergencyCtx(ctx context.Context, msg string, args ...interface{}) error
+	FatalCtx(ctx context.Context, msg string, args ...interface{})
+	LogCtx(ctx context.Context, level Level, msg string, args []interface{})
+}
+
+type registeredKey struct {
+	key  interface{}
+	name string
+}
+
+var contextKeys struct {
+	sync.RWMutex
+	keys []registeredKey
+}
+
+// RegisterContextKey registers a context key whose value is logged under
+// name by context-aware methods, eg request ID, tenant or trace ID.
+//
+// Example
+// log.RegisterContextKey(requestIDKey, "reqID")
+func RegisterContextKey(key interface{}, name string) {
+	if name == "" {
+		panic("name is empty string")
+	}
+	contextKeys.Lock()
+	defer contextKeys.Unlock()
+	for i, rk := range contextKeys.keys {
+		if rk.key == key {
+			contextKeys.keys[i].name = name
+			return
+		}
+	}
+	contextKeys.keys = append(contextKeys.keys, registeredKey{key: key, name: name})
+}
+
+// NewContext returns a copy of ctx which carries logger.
+func NewContext(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+// FromContext returns the logger carried by ctx or DefaultLog if there is
+// none.
+func FromContext(ctx context.Context) Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerContextKey).(Logger); ok {
+			return logger
+		}
+	}
+	return DefaultLog
+}
+
+// NewLevelContext returns a copy of ctx which overrides the level of
+// loggers for context-aware methods. Use it to log a single request at
+// LevelDebug while the logger stays at LevelError.
+func NewLevelContext(ctx context.Context, level Level) context.Context {
+	return context.WithValue(ctx, levelContextKey, level)
+}
+
+// contextLevel returns the level override carried by ctx.
+func contextLevel(ctx context.Context) (Level, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	level, ok := ctx.Value(levelContextKey).(Level)
+	return level, ok
+}
+
+// contextArgs prepends the values of registered context keys to args.
+func contextArgs(ctx context.Context, args []interface{}) []interface{} {
+	if ctx == nil {
+		return args
+	}
+
+	contextKeys.RLock()
+	defer contextKeys.RUnlock()
+	if len(contextKeys.keys) == 0 {
+		return args
+	}
+
+	var result []interface{}
+	for _, rk := range contextKeys.keys {
+		if val := ctx.Value(rk.key); val != nil {
+			result = append(result, rk.name, val)
+		}
+	}
+	if result == nil {
+		return args
+	}
+	return append(result, balanceArgs(args)...)
+}
+
+func logCtx(ctx context.Context, level Level, msg string, args []interface{}) {
+	logger := FromContext(ctx)
+	if cl, ok := logger.(ContextLogger); ok {
+		cl.LogCtx(ctx, level, msg, args)
+		return
+	}
+	logger.Log(level, msg, contextArgs(ctx, args))
+}
+
+// TraceCtx logs a trace statement with context using the logger carried
+// by ctx.
+func TraceCtx(ctx context.Context, msg string, args ...interface{}) {
+	logCtx(ctx, LevelTrace, msg, args)
+}
+
+// DebugCtx logs a debug statement with context using the logger carried
+// by ctx.
+func DebugCtx(ctx context.Context, msg string, args ...interface{}) {
+	logCtx(ctx, LevelDebug, msg, args)
+}
+
+// InfoCtx logs an info statement with context using the logger carried
+// by ctx.
+func InfoCtx(ctx context.Context, msg string, args ...interface{}) {
+	logCtx(ctx, LevelInfo, msg, args)
+}
+
+// NoticeCtx logs a notice statement with context using the logger carried
+// by ctx.
+func NoticeCtx(ctx context.Context, msg string, args ...interface{}) {
+	logCtx(ctx, LevelNotice, msg, args)
+}
+
+// WarnCtx logs a warning statement with context using the logger carried
+// by ctx.
+func WarnCtx(ctx context.Context, msg string, args ...interface{}) {
+	logCtx(ctx, LevelWarn, msg, args)
+}
+
+// ErrorCtx logs an error statement with context using the logger carried
+// by ctx.
+func ErrorCtx(ctx context.Context, msg string, args ...interface{}) {
+	logCtx(ctx, LevelError, msg, args)
+}
+
+// CriticalCtx logs a critical statement with context using the logger
+// carried by ctx.
+func CriticalCtx(ctx context.Context, msg string, args ...interface{}) {
+	logCtx(ctx, LevelCritical, msg, args)
+}
+
+// AlertCtx logs an alert statement with context using the logger carried
+// by ctx.
+func AlertCtx(ctx context.Context, msg string, args ...interface{}) {
+	logCtx(ctx, LevelAlert, msg, args)
+}
+
+// EmergencyCtx logs an emergency statement with context using the logger
+// carried by ctx.
+func EmergencyCtx(ctx context.Context, msg string, args ...interface{}) {
+	logCtx(ctx, LevelEmergency, msg, args)
+}
+
+// FatalCtx logs a fatal statement with context using the logger carried
+// by ctx.
+func FatalCtx(ctx context.Context, msg string, args ...interface{}) {
+	logger := FromContext(ctx)
+	if cl, ok := logger.(ContextLogger); ok {
+		cl.FatalCtx(ctx, msg, args...)
+		return
+	}
+	logger.Fatal(msg, contextArgs(ctx, args)...)
+}
@@ -0,0 +1,304 @@
+package log
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RepeatedKey, FirstKey and LastKey are the keys of the number of
+// suppressed duplicates and the times of the first and last duplicate in
+// summary entries.
+var (
+	RepeatedKey = "_repeated"
+	FirstKey    = "_first"
+	LastKey     = "_last"
+)
+
+// dedupRule collapses duplicate entries of loggers matching pattern within
+// window.
+type dedupRule struct {
+	pattern string
+	window  time.Duration
+	// keys are the fields whose values are part of the duplicate key
+	keys     []string
+	literals int
+	wilds    int
+
+	mu      sync.Mutex
+	pending map[string]*duplicates
+}
+
+// duplicates tracks the repeats of an entry within a window.
+type duplicates struct {
+	timer *time.Timer
+	// last is the last suppressed entry
+	last  *Entry
+	first time.Time
+	count int
+	// emit writes the summary with the logger of the last duplicate
+	emit func(*Entry)
+}
+
+// logxiDedupRules are the rules of LOGXI_DEDUP ordered from most to least
+// specific
+var logxiDedupRules []*dedupRule
+
+// logxiDedupEnv is the LOGXI_DEDUP value of logxiDedupRules
+var logxiDedupEnv string
+
+// dedupMutex guards logxiDedupRules and logxiDedupEnv
+var dedupMutex sync.Mutex
+
+// ProcessLogxiDedupEnv parses LOGXI_DEDUP. Each rule is a LOGXI name
+// pattern, a window and optional keys separated by "/".
+//
+//     LOGXI_DEDUP=*=30s,db=1m/host/port
+//
+// logs the first entry with the same logger name, level and message and
+// suppresses repeats within 30 seconds. For db and its descendants, the
+// values of host and port, whether logged or bound with With, are part of
+// the duplicate key and the window is a minute. At the end of a window, a
+// summary entry reports the number of repeats and the times of the first
+// and last one. "off" disables deduplication.
+//
+// The rules are kept if env is unchanged so pending windows continue.
+// Otherwise the summaries of the previous rules are written first.
+func ProcessLogxiDedupEnv(env string) {
+	var rules []*dedupRule
+	if env == "off" {
+		env = ""
+	}
+	dedupMutex.Lock()
+	unchanged := env == logxiDedupEnv
+	dedupMutex.Unlock()
+	if unchanged {
+		return
+	}
+
+	for key, value := range parseKVList(env, ",") {
+		rule, err := parseDedupRule(key, value)
+		if err != nil {
+			InternalLog.Error("Invalid rule in LOGXI_DEDUP environment variable", "key", key, "value", value, "err", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.literals != b.literals {
+			return a.literals > b.literals
+		}
+		if a.wilds != b.wilds {
+			return a.wilds < b.wilds
+		}
+		return a.pattern < b.pattern
+	})
+	dedupMutex.Lock()
+	previous := logxiDedupRules
+	logxiDedupRules = rules
+	logxiDedupEnv = env
+	dedupMutex.Unlock()
+
+	for _, rule := range previous {
+		rule.flush()
+	}
+}
+
+func parseDedupRule(key, value string) (*dedupRule, error) {
+	if _, err := path.Match(key, ""); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(value, "/")
+	window, err := time.ParseDuration(parts[0])
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid window %q", parts[0])
+	}
+	rule := &dedupRule{
+		pattern: key,
+		window:  window,
+		keys:    parts[1:],
+		pending: map[string]*duplicates{},
+	}
+	rule.literals, rule.wilds = patternSpecificity(key)
+	return rule, nil
+}
+
+// matchDedupRule returns the most specific rule matching the logger name.
+func matchDedupRule(name string) *dedupRule {
+	dedupMutex.Lock()
+	defer dedupMutex.Unlock()
+	for _, rule := range logxiDedupRules {
+		if matchName(rule.pattern, name) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// key returns the duplicate key of entry logged by a logger which bound the
+// pairs context with With.
+func (r *dedupRule) key(entry *Entry, context []interface{}) string {
+	buf := pool.Get()
+	defer pool.Put(buf)
+	buf.WriteString(entry.Name)
+	buf.WriteByte(0)
+	buf.WriteString(strconv.Itoa(int(entry.Level)))
+	buf.WriteByte(0)
+	buf.WriteString(entry.Message)
+	for _, k := range r.keys {
+		value, ok := entry.Value(k)
+		if !ok {
+			value, _ = boundValue(context, k)
+		}
+		buf.WriteByte(0)
+		buf.WriteString(fmt.Sprint(value))
+	}
+	return buf.String()
+}
+
+// boundValue returns the value of key in the pairs bound with With.
+func boundValue(context []interface{}, key string) (interface{}, bool) {
+	for i := len(context) - 2; i >= 0; i -= 2 {
+		if k, ok := context[i].(string); ok && k == key {
+			return context[i+1], true
+		}
+	}
+	return nil, false
+}
+
+// check returns false if entry duplicates an entry logged within the
+// window. context are the pairs bound to the logger of entry. emit writes
+// the summary at the end of the window.
+func (r *dedupRule) check(entry *Entry, context []interface{}, emit func(*Entry)) bool {
+	key := r.key(entry, context)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.pending[key]
+	if d == nil {
+		d = &duplicates{}
+		r.pending[key] = d
+		d.timer = time.AfterFunc(r.window, func() {
+			r.expire(key, d)
+		})
+		return true
+	}
+
+	if d.count == 0 {
+		d.first = entry.Time
+	}
+	// the summary is written from another goroutine which cannot look up
+	// the caller
+	if entry.Caller == nil {
+		entry.Caller = callerFrame()
+	}
+	d.count++
+	d.last = entry
+	d.emit = emit
+	return false
+}
+
+// expire ends the window of a duplicate key and writes its summary.
+func (r *dedupRule) expire(key string, d *duplicates) {
+	r.mu.Lock()
+	if r.pending[key] == d {
+		delete(r.pending, key)
+	}
+	summary := r.summary(d)
+	r.mu.Unlock()
+
+	if summary != nil {
+		d.emit(summary)
+	}
+}
+
+// summary returns the summary entry of d or nil if there were no
+// duplicates. Keys bound with With are written by the formatter of emit.
+func (r *dedupRule) summary(d *duplicates) *Entry {
+	if d.count == 0 {
+		return nil
+	}
+	last := d.last
+	summary := &Entry{
+		Time:    time.Now(),
+		Level:   last.Level,
+		Name:    last.Name,
+		Message: last.Message + " (repeated " + strconv.Itoa(d.count) + " times in " + r.window.String() + ")",
+		Caller:  last.Caller,
+		Stack:   last.Stack,
+	}
+	for _, k := range r.keys {
+		if value, ok := last.Value(k); ok {
+			summary.Fields = append(summary.Fields, Field{Key: k, Value: value})
+		}
+	}
+	timeFormat := settings().timeFormat
+	summary.Fields = append(summary.Fields,
+		Field{Key: RepeatedKey, Value: d.count},
+		Field{Key: FirstKey, Value: d.first.Format(timeFormat)},
+		Field{Key: LastKey, Value: last.Time.Format(timeFormat)},
+	)
+	return summary
+}
+
+// flush writes the summaries of all pending duplicates and ends their
+// windows.
+func (r *dedupRule) flush() {
+	r.mu.Lock()
+	var summaries []*Entry
+	var emits []func(*Entry)
+	for key, d := range r.pending {
+		d.timer.Stop()
+		delete(r.pending, key)
+		if summary := r.summary(d); summary != nil {
+			summaries = append(summaries, summary)
+			emits = append(emits, d.emit)
+		}
+	}
+	r.mu.Unlock()
+
+	for i, summary := range summaries {
+		emits[i](summary)
+	}
+}
+
+// flushDuplicates writes the summaries of all pending duplicates. It is
+// called by Flush so summaries are not lost on exit.
+func flushDuplicates() {
+	dedupMutex.Lock()
+	rules := logxiDedupRules
+	dedupMutex.Unlock()
+	for _, rule := range rules {
+		rule.flush()
+	}
+}
+
+// dedupSet is the LOGXI_DEDUP rule of a logger. It is shared with child
+// loggers created by With.
+type dedupSet struct {
+	sync.RWMutex
+	rule *dedupRule
+}
+
+func (ds *dedupSet) set(rule *dedupRule) {
+	ds.Lock()
+	ds.rule = rule
+	ds.Unlock()
+}
+
+// check returns false if entry is a duplicate.
+func (ds *dedupSet) check(entry *Entry, context []interface{}, emit func(*Entry)) bool {
+	ds.RLock()
+	rule := ds.rule
+	ds.RUnlock()
+	if rule == nil {
+		return true
+	}
+	return rule.check(entry, context, emit)
+}
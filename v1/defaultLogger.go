@@ -1,53 +1,126 @@
 package log
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"runtime/debug"
+	"sync/atomic"
 )
 
 // DefaultLogger is the default logger for this package.
 type DefaultLogger struct {
-	writer    io.Writer
-	name      string
-	level     int
+	writer io.Writer
+	name   string
+	// level is shared with child loggers created by With
+	level *atomicLevel
+	// format holds the *loggerFormat of this logger. It is swapped when
+	// the configuration changes while entries are logged.
+	format atomic.Value
+	// parent is the registered logger a child created by With derives its
+	// formatters from
+	parent *DefaultLogger
+	// context are the key-value pairs bound with With
+	context []interface{}
+	// hooks are shared with child loggers created by With
+	hooks *hookChain
+	// sampling are the LOGXI_SAMPLE rules of this logger
+	sampling *samplerSet
+	// dedup is the LOGXI_DEDUP rule of this logger
+	dedup *dedupSet
+	// sinks are shared with child loggers created by With
+	sinks *sinkSet
+	// envFormat is true when the formatter is created from LOGXI_FORMAT and
+	// must be recreated when the configuration changes
+	envFormat bool
+	// fatalFunc replaces the fatal policy of loggers passed by CaptureFatal
+	fatalFunc FatalFunc
+}
+
+// loggerFormat are the formatters of a logger.
+type loggerFormat struct {
 	formatter Formatter
+	// base is the formatter without the pairs bound with With. It is used
+	// when hooks run as the pairs are then fields of the entry.
+	base Formatter
+	// parent is the format of the parent these formatters were built from
+	parent *loggerFormat
 }
 
 // NewLogger creates a new default logger. If writer is not concurrent
 // safe, wrap it with NewConcurrentWriter.
 func NewLogger(writer io.Writer, name string) Logger {
-	formatter, err := createFormatter(name, logxiFormat)
+	formatter, err := createFormatter(name, FormatEnv)
 	if err != nil {
 		panic("Could not create formatter")
 	}
-	return NewLogger3(writer, name, formatter)
+	log := buildLogger(writer, name, formatter)
+	log.envFormat = true
+	log.sinks.setEnv(bindEnvSinks(name))
+	log.register()
+	return log
 }
 
 // NewLogger3 creates a new logger with a writer, name and formatter. If writer is not concurrent
 // safe, wrap it with NewConcurrentWriter.
 func NewLogger3(writer io.Writer, name string, formatter Formatter) Logger {
-	var level int
+	return newLogger(writer, name, formatter)
+}
+
+func newLogger(writer io.Writer, name string, formatter Formatter) *DefaultLogger {
+	log := buildLogger(writer, name, formatter)
+	log.register()
+	return log
+}
+
+// buildLogger creates a logger which is not yet registered.
+func buildLogger(writer io.Writer, name string, formatter Formatter) *DefaultLogger {
+	var level Level
 	if name != "__logxi" {
-		// if err is returned, then it means the log is disabled
+		// a disabled logger is LevelOff and is registered so it may be
+		// enabled when the configuration changes
 		level = getLogLevel(name)
-		if level == LevelOff {
-			return NullLog
-		}
 	}
 
 	log := &DefaultLogger{
-		formatter: formatter,
-		writer:    writer,
-		name:      name,
-		level:     level,
+		writer:   writer,
+		name:     name,
+		level:    newAtomicLevel(level),
+		hooks:    &hookChain{},
+		sampling: &samplerSet{rules: matchSampleRules(name)},
+		dedup:    &dedupSet{rule: matchDedupRule(name)},
+		sinks:    &sinkSet{},
 	}
+	log.format.Store(&loggerFormat{formatter: formatter, base: formatter})
+	return log
+}
 
-	// TODO loggers will be used when watching changes to configuration such
-	// as in consul, etcd
+// register adds this logger to the loggers which are reconfigured when the
+// configuration changes. The logger must not be modified afterwards.
+func (l *DefaultLogger) register() {
 	loggers.Lock()
-	loggers.loggers[name] = log
+	loggers.add(l)
 	loggers.Unlock()
-	return log
+}
+
+// formatters returns the formatters of this logger. A child created by With
+// rebuilds its formatters when the formatter of its parent changed.
+func (l *DefaultLogger) formatters() *loggerFormat {
+	format := l.format.Load().(*loggerFormat)
+	if l.parent == nil {
+		return format
+	}
+	parent := l.parent.formatters()
+	if format.parent == parent {
+		return format
+	}
+	format = &loggerFormat{
+		formatter: withContext(parent.base, l.context),
+		base:      parent.base,
+		parent:    parent,
+	}
+	l.format.Store(format)
+	return format
 }
 
 // New creates a colorable default logger.
@@ -55,6 +128,75 @@ func New(name string) Logger {
 	return NewLogger(colorableStdout, name)
 }
 
+// With returns a child logger which logs key-value pairs args with every
+// entry. The pairs are encoded once by the formatter. The child shares the
+// writer, level, formatter and name of its parent.
+func (l *DefaultLogger) With(args ...interface{}) Logger {
+	if len(args) == 0 {
+		return l
+	}
+	context := make([]interface{}, 0, len(l.context)+len(args))
+	context = append(context, l.context...)
+	context = append(context, balanceArgs(args)...)
+	return l.child(context)
+}
+
+// child returns a child logger which logs the key-value pairs context.
+func (l *DefaultLogger) child(context []interface{}) *DefaultLogger {
+	parent := l
+	if l.parent != nil {
+		parent = l.parent
+	}
+	log := &DefaultLogger{
+		writer:    l.writer,
+		name:      l.name,
+		level:     l.level,
+		parent:    parent,
+		context:   context,
+		hooks:     l.hooks,
+		sampling:  l.sampling,
+		dedup:     l.dedup,
+		sinks:     l.sinks,
+		envFormat: l.envFormat,
+		fatalFunc: l.fatalFunc,
+	}
+	format := parent.formatters()
+	log.format.Store(&loggerFormat{
+		formatter: withContext(format.base, context),
+		base:      format.base,
+		parent:    format,
+	})
+	return log
+}
+
+// Named returns a child logger named name.sub, eg "server.http". The level
+// of the child is resolved from LOGXI where the most specific pattern
+// matching the name or its ancestors wins. The child shares the writer and
+// pairs bound with With.
+func (l *DefaultLogger) Named(sub string) Logger {
+	name := sub
+	if l.name != "" && l.name != "~" {
+		name = l.name + "." + sub
+	}
+	format := l.formatters()
+	formatter := withName(format.formatter, name)
+	log := buildLogger(l.writer, name, formatter)
+	log.format.Store(&loggerFormat{formatter: formatter, base: withName(format.base, name)})
+	log.context = l.context
+	log.hooks.hooks = append([]*hook{}, l.hooks.snapshot()...)
+	log.sinks.sinks = l.sinks.named(name)
+	log.envFormat = l.envFormat
+	if log.envFormat {
+		log.sinks.setEnv(bindEnvSinks(name))
+	}
+	log.fatalFunc = l.fatalFunc
+	// loggers of CaptureFatal must not replace registered loggers
+	if log.fatalFunc == nil {
+		log.register()
+	}
+	return log
+}
+
 // Trace logs a debug entry.
 func (l *DefaultLogger) Trace(msg string, args ...interface{}) {
 	l.Log(LevelTrace, msg, args)
@@ -70,6 +212,11 @@ func (l *DefaultLogger) Info(msg string, args ...interface{}) {
 	l.Log(LevelInfo, msg, args)
 }
 
+// Notice logs a notice entry.
+func (l *DefaultLogger) Notice(msg string, args ...interface{}) {
+	l.Log(LevelNotice, msg, args)
+}
+
 // Warn logs a warn entry.
 func (l *DefaultLogger) Warn(msg string, args ...interface{}) error {
 	if l.IsWarn() {
@@ -86,7 +233,7 @@ func (l *DefaultLogger) Warn(msg string, args ...interface{}) error {
 	return nil
 }
 
-func (l *DefaultLogger) extractLogError(level int, msg string, args []interface{}) error {
+func (l *DefaultLogger) extractLogError(level Level, msg string, args []interface{}) error {
 	defer l.Log(level, msg, args)
 
 	for _, arg := range args {
@@ -102,48 +249,233 @@ func (l *DefaultLogger) Error(msg string, args ...interface{}) error {
 	return l.extractLogError(LevelError, msg, args)
 }
 
-// Fatal logs a fatal entry then panics.
+// Critical logs a critical entry.
+func (l *DefaultLogger) Critical(msg string, args ...interface{}) error {
+	return l.extractLogError(LevelCritical, msg, args)
+}
+
+// Alert logs an alert entry.
+func (l *DefaultLogger) Alert(msg string, args ...interface{}) error {
+	return l.extractLogError(LevelAlert, msg, args)
+}
+
+// Emergency logs an emergency entry.
+func (l *DefaultLogger) Emergency(msg string, args ...interface{}) error {
+	return l.extractLogError(LevelEmergency, msg, args)
+}
+
+// Fatal logs a fatal entry then runs the exit handlers, flushes writers and
+// applies the fatal policy set with SetFatalFunc.
 func (l *DefaultLogger) Fatal(msg string, args ...interface{}) {
-	l.extractLogError(LevelFatal, msg, args)
-	defer panic("Exit due to fatal error: ")
+	l.Log(LevelFatal, msg, args)
+	fatal(l.writer, msg, firstError(args), l.fatalFunc)
 }
 
 // Log logs a leveled entry.
-func (l *DefaultLogger) Log(level int, msg string, args []interface{}) {
+func (l *DefaultLogger) Log(level Level, msg string, args []interface{}) {
 	// log if the log level (warn=4) >= level of message (err=3)
-	if l.level < level || silent {
+	if l.level.get() < level || silent {
 		return
 	}
-	l.formatter.Format(l.writer, level, msg, args)
+	l.log(NewEntry(level, l.name, msg, args))
+}
+
+// log samples and deduplicates entry before writing it.
+func (l *DefaultLogger) log(entry *Entry) {
+	if !l.sampling.sample(entry) {
+		return
+	}
+	if !l.dedup.check(entry, l.context, l.write) {
+		return
+	}
+	l.write(entry)
+}
+
+// write runs the hooks and formats entry for the writer and sinks.
+func (l *DefaultLogger) write(entry *Entry) {
+	if entry.Caller == nil && (needsCaller(entry.Level) || l.formattersNeedCaller()) {
+		entry.Caller = callerFrame()
+	}
+
+	format := l.formatters()
+	formatter := format.formatter
+	hasHooks := l.hasHooks()
+	if hasHooks {
+		// hooks see the pairs bound with With
+		if len(l.context) > 0 {
+			entry.Fields = append(argsToFields(l.context), entry.Fields...)
+			formatter = format.base
+		}
+		if !l.runHooks(entry) {
+			return
+		}
+		if entry.Stack == "" && hasError(entry.Fields) {
+			entry.Stack = string(debug.Stack())
+		}
+	}
+	if l.writer != nil {
+		writeEntry(formatter, l.writer, entry)
+	}
+	l.writeSinks(entry, hasHooks)
+}
+
+// TraceCtx logs a trace entry with context.
+func (l *DefaultLogger) TraceCtx(ctx context.Context, msg string, args ...interface{}) {
+	l.LogCtx(ctx, LevelTrace, msg, args)
+}
+
+// DebugCtx logs a debug entry with context.
+func (l *DefaultLogger) DebugCtx(ctx context.Context, msg string, args ...interface{}) {
+	l.LogCtx(ctx, LevelDebug, msg, args)
+}
+
+// InfoCtx logs an info entry with context.
+func (l *DefaultLogger) InfoCtx(ctx context.Context, msg string, args ...interface{}) {
+	l.LogCtx(ctx, LevelInfo, msg, args)
+}
+
+// NoticeCtx logs a notice entry with context.
+func (l *DefaultLogger) NoticeCtx(ctx context.Context, msg string, args ...interface{}) {
+	l.LogCtx(ctx, LevelNotice, msg, args)
+}
+
+// WarnCtx logs a warn entry with context.
+func (l *DefaultLogger) WarnCtx(ctx context.Context, msg string, args ...interface{}) error {
+	if l.levelCtx(ctx) >= LevelWarn {
+		defer l.LogCtx(ctx, LevelWarn, msg, args)
+
+		for _, arg := range args {
+			if err, ok := arg.(error); ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *DefaultLogger) extractLogErrorCtx(ctx context.Context, level Level, msg string, args []interface{}) error {
+	defer l.LogCtx(ctx, level, msg, args)
+
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			return err
+		}
+	}
+	return fmt.Errorf(msg)
+}
+
+// ErrorCtx logs an error entry with context.
+func (l *DefaultLogger) ErrorCtx(ctx context.Context, msg string, args ...interface{}) error {
+	return l.extractLogErrorCtx(ctx, LevelError, msg, args)
+}
+
+// CriticalCtx logs a critical entry with context.
+func (l *DefaultLogger) CriticalCtx(ctx context.Context, msg string, args ...interface{}) error {
+	return l.extractLogErrorCtx(ctx, LevelCritical, msg, args)
+}
+
+// AlertCtx logs an alert entry with context.
+func (l *DefaultLogger) AlertCtx(ctx context.Context, msg string, args ...interface{}) error {
+	return l.extractLogErrorCtx(ctx, LevelAlert, msg, args)
+}
+
+// EmergencyCtx logs an emergency entry with context.
+func (l *DefaultLogger) EmergencyCtx(ctx context.Context, msg string, args ...interface{}) error {
+	return l.extractLogErrorCtx(ctx, LevelEmergency, msg, args)
+}
+
+// FatalCtx logs a fatal entry with context then applies the fatal policy
+// like Fatal.
+func (l *DefaultLogger) FatalCtx(ctx context.Context, msg string, args ...interface{}) {
+	l.LogCtx(ctx, LevelFatal, msg, args)
+	fatal(l.writer, msg, firstError(args), l.fatalFunc)
+}
+
+// levelCtx returns the level override carried by ctx or the level of this
+// logger.
+func (l *DefaultLogger) levelCtx(ctx context.Context) Level {
+	if level, ok := contextLevel(ctx); ok {
+		return level
+	}
+	return l.level.get()
+}
+
+// LogCtx logs a leveled entry with the values of keys registered with
+// RegisterContextKey. The level of ctx, if any, overrides the level of this
+// logger.
+func (l *DefaultLogger) LogCtx(ctx context.Context, level Level, msg string, args []interface{}) {
+	if l.levelCtx(ctx) < level || silent {
+		return
+	}
+	l.log(NewEntry(level, l.name, msg, contextArgs(ctx, args)))
 }
 
 // IsTrace determines if this logger logs a debug statement.
 func (l *DefaultLogger) IsTrace() bool {
 	// DEBUG(7) >= TRACE(10)
-	return l.level >= LevelTrace
+	return l.level.get() >= LevelTrace
 }
 
 // IsDebug determines if this logger logs a debug statement.
 func (l *DefaultLogger) IsDebug() bool {
-	return l.level >= LevelDebug
+	return l.level.get() >= LevelDebug
 }
 
 // IsInfo determines if this logger logs an info statement.
 func (l *DefaultLogger) IsInfo() bool {
-	return l.level >= LevelInfo
+	return l.level.get() >= LevelInfo
+}
+
+// IsNotice determines if this logger logs a notice statement.
+func (l *DefaultLogger) IsNotice() bool {
+	return l.level.get() >= LevelNotice
 }
 
 // IsWarn determines if this logger logs a warning statement.
 func (l *DefaultLogger) IsWarn() bool {
-	return l.level >= LevelWarn
+	return l.level.get() >= LevelWarn
+}
+
+// IsError determines if this logger logs an error statement.
+func (l *DefaultLogger) IsError() bool {
+	return l.level.get() >= LevelError
 }
 
 // SetLevel sets the level of this logger.
-func (l *DefaultLogger) SetLevel(level int) {
-	l.level = level
+func (l *DefaultLogger) SetLevel(level Level) {
+	l.level.set(level)
+}
+
+// reconfigure re-resolves the level and, if created from LOGXI_FORMAT, the
+// formatter of this logger from the current configuration.
+func (l *DefaultLogger) reconfigure() {
+	l.level.set(getLogLevel(l.name))
+	if !l.envFormat {
+		return
+	}
+	formatter, err := createFormatter(l.name, FormatEnv)
+	if err != nil {
+		InternalLog.Error("Could not create formatter", "name", l.name, "err", err)
+		return
+	}
+	l.setFormatter(formatter)
+	l.sinks.setEnv(bindEnvSinks(l.name))
 }
 
-// SetFormatter set the formatter for this logger.
+// SetFormatter set the formatter for this logger. The formatter is shared
+// with child loggers created by With.
 func (l *DefaultLogger) SetFormatter(formatter Formatter) {
-	l.formatter = formatter
+	if l.parent != nil {
+		l.parent.setFormatter(formatter)
+		return
+	}
+	l.setFormatter(formatter)
+}
+
+func (l *DefaultLogger) setFormatter(base Formatter) {
+	formatter := base
+	if len(l.context) > 0 {
+		formatter = withContext(formatter, l.context)
+	}
+	l.format.Store(&loggerFormat{formatter: formatter, base: base})
 }
@@ -0,0 +1,176 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ECSVersion is the version of the Elastic Common Schema of ECSFormatter
+const ECSVersion = "8.11.0"
+
+// ECSFormatter formats entries as Elastic Common Schema JSON which
+// Elasticsearch ingests without pipelines: @timestamp, log.level,
+// log.logger, message, process.pid, log.origin of the caller, ecs.version
+// and error.message, error.type and error.stack_trace of the first error.
+// Other key-value pairs are mapped to ECS fields by KeyTable or placed under
+// Namespace. Dotted keys are expanded into objects, eg "http.request.method"
+// is {"http":{"request":{"method":...}}}. A later pair replaces an earlier
+// one with the same key, but pairs do not replace the fields above.
+type ECSFormatter struct {
+	name string
+	// Namespace is the object of pairs which are not in KeyTable, eg
+	// "labels" or the name of the service. If empty, they are top-level
+	// fields.
+	Namespace string
+	// KeyTable maps keys to ECS fields, eg "user" to "user.name".
+	KeyTable map[string]string
+}
+
+// NewECSFormatter creates a new ECSFormatter. Namespace defaults to the
+// namespace of LOGXI_FORMAT.
+//
+// Example
+// formatter := log.NewECSFormatter("app")
+// formatter.Namespace = "app"
+// formatter.KeyTable = map[string]string{"user": "user.name", "reqID": "http.request.id"}
+// logger := log.NewLogger3(os.Stdout, "app", formatter)
+func NewECSFormatter(name string) *ECSFormatter {
+	return &ECSFormatter{name: name, Namespace: settings().namespace}
+}
+
+// WithName returns a copy of the formatter for name.
+func (ef *ECSFormatter) WithName(name string) Formatter {
+	c := *ef
+	c.name = name
+	return &c
+}
+
+// Format formats a log entry as ECS JSON.
+func (ef *ECSFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	ef.FormatEntry(writer, NewEntry(level, ef.name, msg, args))
+}
+
+// FormatEntry formats entry as an ECS JSON line.
+func (ef *ECSFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	root := &ecsObject{}
+	ef.setECSFields(root, entry)
+	var hasErr bool
+	for _, field := range entry.Fields {
+		if _, ok := field.Value.(error); ok && !hasErr {
+			hasErr = true
+			continue
+		}
+		root.set(ef.fieldName(field.Key), field.Value)
+	}
+	// pairs do not replace the ECS fields
+	ef.setECSFields(root, entry)
+
+	buf := pool.Get()
+	defer pool.Put(buf)
+	root.writeTo(buf)
+	buf.WriteByte('\n')
+	buf.WriteTo(writer)
+}
+
+// setECSFields sets the fields which are not key-value pairs.
+func (ef *ECSFormatter) setECSFields(root *ecsObject, entry *Entry) {
+	root.set("@timestamp", entry.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+	root.set("log.level", levelName(entry.Level))
+	root.set("log.logger", ef.name)
+	root.set("message", entry.Message)
+	root.set("process.pid", pid)
+	if entry.Caller != nil {
+		root.set("log.origin.file.name", entry.Caller.File)
+		root.set("log.origin.file.line", entry.Caller.Line)
+		root.set("log.origin.function", entry.Caller.Function)
+	}
+	for _, field := range entry.Fields {
+		if err, ok := field.Value.(error); ok {
+			root.set("error.message", err.Error())
+			root.set("error.type", fmt.Sprintf("%T", err))
+			root.set("error.stack_trace", entry.Stack)
+			break
+		}
+	}
+	root.set("ecs.version", ECSVersion)
+}
+
+// fieldName returns the dotted field name of key.
+func (ef *ECSFormatter) fieldName(key string) string {
+	if name, ok := ef.KeyTable[key]; ok {
+		return name
+	}
+	if ef.Namespace == "" {
+		return key
+	}
+	return ef.Namespace + "." + key
+}
+
+// ecsObject is a JSON object whose fields are kept in order.
+type ecsObject struct {
+	keys   []string
+	values []interface{}
+}
+
+// set sets the field at the dotted path name, replacing values in the
+// way with objects.
+func (eo *ecsObject) set(name string, value interface{}) {
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '.' })
+	if len(parts) == 0 {
+		parts = []string{name}
+	}
+	obj := eo
+	for _, part := range parts[:len(parts)-1] {
+		obj = obj.object(part)
+	}
+	obj.put(parts[len(parts)-1], value)
+}
+
+// object returns the object field key, which is added or replaces a value.
+func (eo *ecsObject) object(key string) *ecsObject {
+	for i, k := range eo.keys {
+		if k == key {
+			if child, ok := eo.values[i].(*ecsObject); ok {
+				return child
+			}
+			child := &ecsObject{}
+			eo.values[i] = child
+			return child
+		}
+	}
+	child := &ecsObject{}
+	eo.keys = append(eo.keys, key)
+	eo.values = append(eo.values, child)
+	return child
+}
+
+// put sets the field key to value.
+func (eo *ecsObject) put(key string, value interface{}) {
+	for i, k := range eo.keys {
+		if k == key {
+			eo.values[i] = value
+			return
+		}
+	}
+	eo.keys = append(eo.keys, key)
+	eo.values = append(eo.values, value)
+}
+
+func (eo *ecsObject) writeTo(buf *bytes.Buffer) {
+	buf.WriteByte('{')
+	for i, key := range eo.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		valueFormatter.writeString(buf, key)
+		buf.WriteByte(':')
+		if child, ok := eo.values[i].(*ecsObject); ok {
+			child.writeTo(buf)
+		} else {
+			valueFormatter.appendValue(buf, eo.values[i])
+		}
+	}
+	buf.WriteByte('}')
+}
@@ -0,0 +1,227 @@
+package log
+
+import (
+	"io"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Field is a key-value pair of an Entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Entry is a log entry. DefaultLogger builds it once per call and passes it
+// to formatters which implement EntryFormatter, so arguments are validated
+// and the time, caller and error stack are computed only once.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Name    string
+	Message string
+	// Fields are the key-value pairs of the entry in the order they were
+	// logged. A single argument is keyed "_", imbalanced pairs are keyed
+	// FIX_IMBALANCED_PAIRS and invalid keys BAD_KEY_AT_INDEX_n. Pairs bound
+	// with Logger.With are fields when hooks run, otherwise they are
+	// written by the formatter.
+	Fields []Field
+	// Caller is the frame which logged the entry. It is only set by loggers
+	// for LevelTrace and LevelWarn or more severe levels.
+	Caller *runtime.Frame
+	// Stack is the stack trace of the goroutine if a field is an error.
+	Stack string
+}
+
+// EntryFormatter formats entries built by the logger. Formatters should
+// implement it in addition to Formatter.
+type EntryFormatter interface {
+	FormatEntry(writer io.Writer, entry *Entry)
+}
+
+// NewEntry creates an entry for a logger named name at the current time.
+// args are key-value pairs as passed to Logger methods.
+func NewEntry(level Level, name string, msg string, args []interface{}) *Entry {
+	entry := &Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Name:    name,
+		Message: msg,
+		Fields:  argsToFields(args),
+	}
+	if hasError(entry.Fields) {
+		entry.Stack = string(debug.Stack())
+	}
+	return entry
+}
+
+// Args returns the fields of the entry as key-value pairs for formatters
+// which only implement Formatter.
+func (e *Entry) Args() []interface{} {
+	return fieldsToArgs(e.Fields)
+}
+
+// Value returns the value of the last field named key.
+func (e *Entry) Value(key string) (interface{}, bool) {
+	for i := len(e.Fields) - 1; i >= 0; i-- {
+		if e.Fields[i].Key == key {
+			return e.Fields[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+// argsToFields converts key-value pairs to fields keyed the same way
+// formatters log a single argument, imbalanced pairs and invalid keys.
+func argsToFields(args []interface{}) []Field {
+	lenArgs := len(args)
+	if lenArgs == 0 {
+		return nil
+	}
+	if lenArgs == 1 {
+		return []Field{{Key: singleArgKey, Value: args[0]}}
+	}
+	if lenArgs%2 != 0 {
+		return []Field{{Key: warnImbalancedKey, Value: args}}
+	}
+
+	fields := make([]Field, 0, lenArgs/2)
+	for i := 0; i < lenArgs; i += 2 {
+		key, ok := args[i].(string)
+		if !ok || key == "" {
+			// show key is invalid
+			key = badKeyAtIndex(i)
+		}
+		fields = append(fields, Field{Key: key, Value: args[i+1]})
+	}
+	return fields
+}
+
+func fieldsToArgs(fields []Field) []interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(fields)*2)
+	for _, field := range fields {
+		args = append(args, field.Key, field.Value)
+	}
+	return args
+}
+
+func hasError(fields []Field) bool {
+	for _, field := range fields {
+		if _, ok := field.Value.(error); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// logxiPkgPath is the import path of this package
+var logxiPkgPath = reflect.TypeOf(Field{}).PkgPath()
+
+// callerFrame returns the first frame outside of this package.
+func callerFrame() *runtime.Frame {
+	var pcs [16]uintptr
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		// need to see callers in tests
+		if !strings.HasPrefix(frame.Function, logxiPkgPath+".") || strings.HasSuffix(frame.File, "_test.go") {
+			return &frame
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// needsCaller reports whether loggers set the caller of entries of level.
+func needsCaller(level Level) bool {
+	return level == LevelTrace || (level >= LevelFatal && level <= LevelWarn)
+}
+
+// CallerFormatter is implemented by formatters which log the caller of
+// entries of every level. Otherwise loggers only look up the caller of
+// traces, warnings and more severe entries.
+type CallerFormatter interface {
+	Formatter
+	NeedsCaller() bool
+}
+
+// formatterNeedsCaller reports whether formatter, or the formatter it
+// wraps, needs the caller of every entry.
+func formatterNeedsCaller(formatter Formatter) bool {
+	switch f := formatter.(type) {
+	case *contextFormatter:
+		return formatterNeedsCaller(f.formatter)
+	case *entryFormatterAdapter:
+		cf, ok := f.formatter.(CallerFormatter)
+		return ok && cf.NeedsCaller()
+	case CallerFormatter:
+		return f.NeedsCaller()
+	}
+	return false
+}
+
+// formatEntry formats entry with formatter, adapting formatters which do
+// not implement EntryFormatter.
+func formatEntry(formatter Formatter, writer io.Writer, entry *Entry) {
+	if ef, ok := formatter.(EntryFormatter); ok {
+		ef.FormatEntry(writer, entry)
+		return
+	}
+	formatter.Format(writer, entry.Level, entry.Message, entry.Args())
+}
+
+// entryFormatterAdapter adapts an EntryFormatter to Formatter.
+type entryFormatterAdapter struct {
+	name      string
+	formatter EntryFormatter
+}
+
+// NewEntryFormatterAdapter returns a Formatter for a logger named name
+// which formats with formatter. Use it to return EntryFormatters from a
+// CreateFormatterFunc registered with RegisterFormatFactory.
+func NewEntryFormatterAdapter(name string, formatter EntryFormatter) Formatter {
+	if f, ok := formatter.(Formatter); ok {
+		return f
+	}
+	return &entryFormatterAdapter{name: name, formatter: formatter}
+}
+
+func (efa *entryFormatterAdapter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	efa.formatter.FormatEntry(writer, NewEntry(level, efa.name, msg, args))
+}
+
+func (efa *entryFormatterAdapter) FormatEntry(writer io.Writer, entry *Entry) {
+	efa.formatter.FormatEntry(writer, entry)
+}
+
+// WithName returns an adapter for name.
+func (efa *entryFormatterAdapter) WithName(name string) Formatter {
+	return &entryFormatterAdapter{name: name, formatter: efa.formatter}
+}
+
+// formatterAdapter adapts a Formatter to EntryFormatter.
+type formatterAdapter struct {
+	formatter Formatter
+}
+
+// NewFormatterAdapter returns an EntryFormatter which formats with
+// formatter. Formatters which only implement Formatter are passed the
+// level, message and fields of entries.
+func NewFormatterAdapter(formatter Formatter) EntryFormatter {
+	if ef, ok := formatter.(EntryFormatter); ok {
+		return ef
+	}
+	return &formatterAdapter{formatter: formatter}
+}
+
+func (fa *formatterAdapter) FormatEntry(writer io.Writer, entry *Entry) {
+	fa.formatter.Format(writer, entry.Level, entry.Message, entry.Args())
+}
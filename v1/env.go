@@ -2,11 +2,55 @@ package log
 
 import (
 	"os"
+	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-var contextLines int
+// formatSettings are the settings of LOGXI_FORMAT and LOGXI_COLORS read by
+// formatters. They are replaced as a whole so entries may be formatted
+// while the configuration changes.
+type formatSettings struct {
+	// format is the formatter kind to create
+	format       string
+	timeFormat   string
+	isPretty     bool
+	maxCol       int
+	contextLines int
+	// facility is the facility of SyslogFormatter, eg "syslog,facility=local0"
+	facility Facility
+	// columns are the columns of ColumnFormatter, eg "csv,columns=_t/_l/_m/user"
+	columns []string
+	// namespace is the namespace of ECSFormatter, eg "ecs,namespace=app"
+	namespace string
+	theme     *colorScheme
+}
+
+var settingsValue = func() *atomic.Value {
+	var value atomic.Value
+	value.Store(&formatSettings{maxCol: defaultMaxCol, facility: FacilityUser, theme: &colorScheme{}})
+	return &value
+}()
+
+// settingsMutex serializes updates of settingsValue
+var settingsMutex sync.Mutex
+
+// settings returns the current LOGXI_FORMAT and LOGXI_COLORS settings.
+func settings() *formatSettings {
+	return settingsValue.Load().(*formatSettings)
+}
+
+// updateSettings replaces the settings with a copy changed by update.
+func updateSettings(update func(fs *formatSettings)) {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	fs := *settings()
+	update(&fs)
+	settingsValue.Store(&fs)
+}
 
 // Configuration comes from environment or external services like
 // consul, etcd.
@@ -14,6 +58,9 @@ type Configuration struct {
 	Format string `json:"format"`
 	Colors string `json:"colors"`
 	Levels string `json:"levels"`
+	Sample string `json:"sample"`
+	Dedup  string `json:"dedup"`
+	Sinks  string `json:"sinks"`
 }
 
 func readFromEnviron() *Configuration {
@@ -30,60 +77,88 @@ func readFromEnviron() *Configuration {
 	conf.Levels = envOrDefault("LOGXI", defaultLogxiEnv)
 	conf.Format = envOrDefault("LOGXI_FORMAT", defaultLogxiFormatEnv)
 	conf.Colors = envOrDefault("LOGXI_COLORS", defaultLogxiColorsEnv)
+	conf.Sample = os.Getenv("LOGXI_SAMPLE")
+	conf.Dedup = os.Getenv("LOGXI_DEDUP")
+	conf.Sinks = os.Getenv("LOGXI_SINKS")
 	return conf
 }
 
-// ProcessEnv (re)processes environment.
+// ProcessEnv (re)processes environment. Levels and formatters of existing
+// loggers are reapplied. Use Subscribe to process configurations from
+// files or external services.
 func ProcessEnv(env *Configuration) {
-	// TODO: allow reading from etcd
+	pkgMutex.Lock()
+	defer pkgMutex.Unlock()
+	applyConfiguration(env)
+}
 
+// applyConfiguration processes env. pkgMutex must be held.
+func applyConfiguration(env *Configuration) {
+	conf := *env
+	logxiConfig = &conf
 	ProcessLogxiEnv(env.Levels)
 	ProcessLogxiColorsEnv(env.Colors)
 	ProcessLogxiFormatEnv(env.Format)
+	ProcessLogxiSampleEnv(env.Sample)
+	ProcessLogxiDedupEnv(env.Dedup)
+	ProcessLogxiSinksEnv(env.Sinks)
+	loggers.reconfigure(true)
 }
 
-// ProcessLogxiFormatEnv parses LOGXI_FORMAT
+// ProcessLogxiFormatEnv parses LOGXI_FORMAT. Keys which are not set are
+// reset to their defaults.
 func ProcessLogxiFormatEnv(env string) {
-	logxiFormat = env
-	m := parseKVList(logxiFormat, ",")
-	formatterFormat := ""
-	tFormat := ""
-	for key, value := range m {
-		switch key {
-		default:
-			formatterFormat = key
-		case "t":
-			tFormat = value
-		case "pretty":
-			isPretty = value != "false" && value != "0"
-		case "maxcol":
-			col, err := strconv.Atoi(value)
-			if err == nil {
-				maxCol = col
-			} else {
-				maxCol = defaultMaxCol
-			}
-		case "context":
-			lines, err := strconv.Atoi(value)
-			if err == nil {
-				contextLines = lines
-			} else {
-				contextLines = defaultContextLines
+	updateSettings(func(fs *formatSettings) {
+		fs.format = ""
+		fs.timeFormat = ""
+		fs.isPretty = defaultPretty
+		fs.maxCol = defaultMaxCol
+		fs.contextLines = defaultContextLines
+		fs.facility = FacilityUser
+		fs.columns = nil
+		fs.namespace = ""
+		for key, value := range parseKVList(env, ",") {
+			switch key {
+			default:
+				fs.format = key
+			case "t":
+				fs.timeFormat = value
+			case "pretty":
+				fs.isPretty = value != "false" && value != "0"
+			case "maxcol":
+				col, err := strconv.Atoi(value)
+				if err == nil {
+					fs.maxCol = col
+				} else {
+					fs.maxCol = defaultMaxCol
+				}
+			case "context":
+				lines, err := strconv.Atoi(value)
+				if err == nil {
+					fs.contextLines = lines
+				} else {
+					fs.contextLines = defaultContextLines
+				}
+			case "facility":
+				f, err := ParseFacility(value)
+				if err != nil {
+					InternalLog.Error("Invalid facility in LOGXI_FORMAT environment variable", "value", value, "err", err)
+					continue
+				}
+				fs.facility = f
+			case "columns":
+				fs.columns = strings.Split(value, "/")
+			case "namespace":
+				fs.namespace = value
 			}
-		case "LTSV":
-			formatterFormat = "text"
-			AssignmentChar = ltsvAssignmentChar
-			Separator = ltsvSeparator
 		}
-	}
-	if formatterFormat == "" || formatterCreators[formatterFormat] == nil {
-		formatterFormat = defaultFormat
-	}
-	logxiFormat = formatterFormat
-	if tFormat == "" {
-		tFormat = defaultTimeFormat
-	}
-	timeFormat = tFormat
+		if fs.format == "" || formatterCreators[fs.format] == nil {
+			fs.format = defaultFormat
+		}
+		if fs.timeFormat == "" {
+			fs.timeFormat = defaultTimeFormat
+		}
+	})
 }
 
 // ProcessLogxiEnv parses LOGXI variable
@@ -93,7 +168,7 @@ func ProcessLogxiEnv(env string) {
 		logxiEnable = defaultLogxiEnv
 	}
 
-	logxiNameLevelMap = map[string]int{}
+	logxiNameLevelMap = map[string]Level{}
 	m := parseKVList(logxiEnable, ",")
 	if m == nil {
 		logxiNameLevelMap["*"] = defaultLevel
@@ -107,8 +182,8 @@ func ProcessLogxiEnv(env string) {
 			logxiNameLevelMap[key] = LevelAll
 		} else {
 			// LOGXI=*=ERR => use user-specified level
-			level := LevelAtoi[value]
-			if level == 0 {
+			level, err := ParseLevel(value)
+			if err != nil {
 				InternalLog.Error("Unknown level in LOGXI environment variable", "key", key, "value", value, "LOGXI", env)
 				level = defaultLevel
 			}
@@ -120,37 +195,135 @@ func ProcessLogxiEnv(env string) {
 	if _, ok := logxiNameLevelMap["*"]; !ok {
 		logxiNameLevelMap["*"] = LevelError
 	}
+	sortLevelPatterns()
 }
 
-func getLogLevel(name string) int {
-	var wildcardLevel int
-	var result int
+// levelPattern is a LOGXI name pattern and its level.
+type levelPattern struct {
+	pattern  string
+	level    Level
+	literals int
+	wilds    int
+}
+
+// patternSpecificity counts the literal runes and the wildcards of a
+// path.Match pattern.
+func patternSpecificity(pattern string) (literals int, wilds int) {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+			wilds++
+		case '[':
+			wilds++
+			for i < len(pattern) && pattern[i] != ']' {
+				i++
+			}
+		case '\\':
+			i++
+			literals++
+		default:
+			literals++
+		}
+	}
+	return literals, wilds
+}
 
+// sortLevelPatterns orders the patterns of logxiNameLevelMap from most to
+// least specific so levels resolve deterministically. The global "*" is
+// not included as it is the fallback.
+func sortLevelPatterns() {
+	patterns := make([]*levelPattern, 0, len(logxiNameLevelMap))
 	for k, v := range logxiNameLevelMap {
-		if k == name {
-			result = v
-		} else if k == "*" {
-			wildcardLevel = v
-		} else if strings.HasPrefix(k, "*") && strings.HasSuffix(name, k[1:]) {
-			result = v
-		} else if strings.HasSuffix(k, "*") && strings.HasPrefix(name, k[:len(k)-1]) {
-			result = v
+		if k == "*" {
+			continue
+		}
+		if _, err := path.Match(k, ""); err != nil {
+			InternalLog.Error("Invalid pattern in LOGXI environment variable", "pattern", k, "err", err)
+			continue
 		}
+		literals, wilds := patternSpecificity(k)
+		patterns = append(patterns, &levelPattern{pattern: k, level: v, literals: literals, wilds: wilds})
 	}
 
-	if result == LevelOff {
-		return LevelOff
+	sort.Slice(patterns, func(i, j int) bool {
+		a, b := patterns[i], patterns[j]
+		if a.literals != b.literals {
+			return a.literals > b.literals
+		}
+		if a.wilds != b.wilds {
+			return a.wilds < b.wilds
+		}
+		return a.pattern < b.pattern
+	})
+	logxiLevelPatterns = patterns
+}
+
+// matchLogLevel returns the LOGXI pattern matching the dotted logger name
+// or its nearest ancestor, and its level. For the name "server.http.router",
+// the candidates are "server.http.router", "server.http" and "server" in
+// that order. The most specific pattern matching the first candidate with
+// any match wins.
+func matchLogLevel(name string) (string, Level) {
+	candidate := name
+	for {
+		for _, lp := range logxiLevelPatterns {
+			if ok, _ := path.Match(lp.pattern, candidate); ok {
+				return lp.pattern, lp.level
+			}
+		}
+		idx := strings.LastIndex(candidate, ".")
+		if idx < 0 {
+			break
+		}
+		candidate = candidate[:idx]
 	}
 
-	if result > 0 {
-		return result
+	if level, ok := logxiNameLevelMap["*"]; ok {
+		return "*", level
 	}
+	return "", LevelOff
+}
+
+// matchName reports whether pattern matches the dotted logger name or one
+// of its ancestors.
+func matchName(pattern string, name string) bool {
+	candidate := name
+	for {
+		if ok, _ := path.Match(pattern, candidate); ok {
+			return true
+		}
+		idx := strings.LastIndex(candidate, ".")
+		if idx < 0 {
+			return false
+		}
+		candidate = candidate[:idx]
+	}
+}
 
-	if wildcardLevel > 0 {
-		return wildcardLevel
+// formatLogxiEnv formats logxiNameLevelMap as a LOGXI value.
+func formatLogxiEnv() string {
+	keys := make([]string, 0, len(logxiNameLevelMap))
+	for k := range logxiNameLevelMap {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+
+	buf := pool.Get()
+	defer pool.Put(buf)
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteRune(',')
+		}
+		buf.WriteString(k)
+		buf.WriteRune('=')
+		buf.WriteString(logxiNameLevelMap[k].String())
+	}
+	return buf.String()
+}
 
-	return LevelOff
+func getLogLevel(name string) Level {
+	_, level := matchLogLevel(name)
+	return level
 }
 
 // ProcessLogxiColorsEnv parases LOGXI_COLORS
@@ -162,5 +335,8 @@ func ProcessLogxiColorsEnv(env string) {
 		// disable all colors
 		disableColors = true
 	}
-	theme = parseTheme(colors)
+	theme := parseTheme(colors)
+	updateSettings(func(fs *formatSettings) {
+		fs.theme = theme
+	})
 }
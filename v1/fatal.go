@@ -0,0 +1,213 @@
+package log
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// FatalError is the panic value of Fatal when the fatal policy is
+// PanicOnFatal and the value returned by CaptureFatal.
+type FatalError struct {
+	// Msg is the message of the fatal entry
+	Msg string
+	// Err is the first error in the arguments of the fatal entry, if any
+	Err error
+}
+
+func (fe *FatalError) Error() string {
+	if fe.Err != nil {
+		return "Exit due to fatal error: " + fe.Msg + ": " + fe.Err.Error()
+	}
+	return "Exit due to fatal error: " + fe.Msg
+}
+
+// FatalFunc is called by Fatal after the entry is logged, exit handlers
+// have run and writers are flushed. It should not return.
+type FatalFunc func(fe *FatalError)
+
+// PanicOnFatal panics with the *FatalError. It is the default fatal policy.
+func PanicOnFatal(fe *FatalError) {
+	panic(fe)
+}
+
+// ExitOnFatal returns a fatal policy which exits the process with code.
+func ExitOnFatal(code int) FatalFunc {
+	return func(fe *FatalError) {
+		osExit(code)
+	}
+}
+
+// osExit may be replaced in tests
+var osExit = os.Exit
+
+var fatalPolicy = struct {
+	sync.Mutex
+	fn       FatalFunc
+	handlers []func()
+}{fn: PanicOnFatal}
+
+// SetFatalFunc sets what Fatal does after logging, eg PanicOnFatal,
+// ExitOnFatal(1) or a custom func. It returns the previous policy.
+//
+// Example
+// log.SetFatalFunc(log.ExitOnFatal(1))
+func SetFatalFunc(fn FatalFunc) FatalFunc {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	fatalPolicy.Lock()
+	defer fatalPolicy.Unlock()
+	previous := fatalPolicy.fn
+	fatalPolicy.fn = fn
+	return previous
+}
+
+// RegisterExitHandler registers a handler which is called by Fatal before
+// the process exits, eg to close database connections. Handlers are called
+// in order of registration. A panicking handler does not stop the others.
+func RegisterExitHandler(handler func()) {
+	if handler == nil {
+		panic("handler is nil")
+	}
+	fatalPolicy.Lock()
+	defer fatalPolicy.Unlock()
+	fatalPolicy.handlers = append(fatalPolicy.handlers, handler)
+}
+
+// Flusher is implemented by writers which buffer entries. Fatal flushes the
+// writers of all registered loggers before exiting.
+type Flusher interface {
+	Flush() error
+}
+
+// Flush writes pending summaries of duplicates and flushes the writers of
+// all registered loggers which implement Flusher.
+func Flush() error {
+	return flushWriters(nil)
+}
+
+// flushWriters flushes writer, if not nil, and the writers of all
+// registered loggers. Each writer is flushed once.
+func flushWriters(writer io.Writer) error {
+	flushDuplicates()
+
+	loggers.Lock()
+	writers := make([]io.Writer, 0, len(loggers.loggers)+1)
+	seen := map[io.Writer]bool{}
+	if writer != nil && isComparable(writer) {
+		seen[writer] = true
+		writers = append(writers, writer)
+	}
+	for _, logger := range loggers.loggers {
+		for _, w := range append([]io.Writer{logger.writer}, logger.sinks.writers()...) {
+			if w == nil || !isComparable(w) || seen[w] {
+				continue
+			}
+			seen[w] = true
+			writers = append(writers, w)
+		}
+	}
+	loggers.Unlock()
+
+	var firstErr error
+	for _, w := range writers {
+		if f, ok := w.(Flusher); ok {
+			if err := f.Flush(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// isComparable reports whether w may be used as a map key.
+func isComparable(w io.Writer) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	_ = map[io.Writer]bool{w: true}
+	return true
+}
+
+// fatal runs the exit handlers, flushes writer and the writers of
+// registered loggers and calls fn or, if nil, the fatal policy.
+func fatal(writer io.Writer, msg string, err error, fn FatalFunc) {
+	fatalPolicy.Lock()
+	handlers := append([]func(){}, fatalPolicy.handlers...)
+	if fn == nil {
+		fn = fatalPolicy.fn
+	}
+	fatalPolicy.Unlock()
+
+	for _, handler := range handlers {
+		runExitHandler(handler)
+	}
+	if err := flushWriters(writer); err != nil {
+		InternalLog.Error("Could not flush writers", "err", err)
+	}
+	fn(&FatalError{Msg: msg, Err: err})
+}
+
+// firstError returns the first error in args.
+func firstError(args []interface{}) error {
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func runExitHandler(handler func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			InternalLog.Error("Exit handler panicked", "err", r)
+		}
+	}()
+	handler()
+}
+
+// captured is the panic value of the loggers passed by CaptureFatal
+type captured struct {
+	fe *FatalError
+}
+
+// CaptureFatal calls fn with a child of logger whose Fatal returns the
+// *FatalError to CaptureFatal instead of applying the fatal policy. It
+// returns nil if fn does not call Fatal. Other loggers and goroutines are
+// not affected. Use it in tests. Fatal must be called on the goroutine of
+// fn with the logger passed to fn or its children. logger must be a
+// *DefaultLogger or *NullLogger, other loggers are passed unchanged.
+//
+// Example
+// fe := log.CaptureFatal(logger, func(logger log.Logger) { run(logger) })
+// assert.Equal(t, "could not connect", fe.Msg)
+func CaptureFatal(logger Logger, fn func(logger Logger)) (fe *FatalError) {
+	c := &captured{}
+	capture := func(fe *FatalError) {
+		c.fe = fe
+		panic(c)
+	}
+	switch l := logger.(type) {
+	case *DefaultLogger:
+		child := l.child(l.context)
+		child.fatalFunc = capture
+		logger = child
+	case *NullLogger:
+		logger = &NullLogger{fatalFunc: capture}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if r != c {
+				panic(r)
+			}
+			fe = c.fe
+		}
+	}()
+	fn(logger)
+	return nil
+}
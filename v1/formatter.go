@@ -1,5 +1,10 @@
 package log
 
+import (
+	"io"
+	"runtime/debug"
+)
+
 var formatterCreators = map[string]CreateFormatterFunc{}
 
 // CreateFormatterFunc is a function which creates a new instance
@@ -11,7 +16,7 @@ type CreateFormatterFunc func(name, kind string) (Formatter, error)
 // logger.
 func createFormatter(name string, kind string) (Formatter, error) {
 	if kind == FormatEnv {
-		kind = logxiFormat
+		kind = settings().format
 	}
 	if kind == "" {
 		kind = FormatText
@@ -45,6 +50,32 @@ func formatFactory(name string, kind string) (Formatter, error) {
 		formatter = NewTextFormatter(name)
 	case FormatJSON:
 		formatter = NewJSONFormatter(name)
+	case FormatSyslog:
+		formatter = NewSyslogFormatter(name)
+	case FormatLogfmt:
+		formatter = NewLogfmtFormatter(name)
+	case FormatGELF:
+		formatter = NewGELFFormatter(name)
+	case FormatLTSV:
+		formatter = NewLTSVFormatter(name)
+	case FormatCSV:
+		formatter = NewCSVFormatter(name)
+	case FormatTSV:
+		formatter = NewTSVFormatter(name)
+	case FormatCBOR:
+		formatter = NewCBORFormatter(name)
+	case FormatMsgpack:
+		formatter = NewMsgpackFormatter(name)
+	case FormatOTel:
+		formatter = NewOTelFormatter(name)
+	case FormatECS:
+		formatter = NewECSFormatter(name)
+	case FormatJournald:
+		formatter = NewJournalFormatter(name)
+	case FormatSyslog3164:
+		sf := NewSyslogFormatter(name)
+		sf.RFC3164 = true
+		formatter = sf
 	}
 	return formatter, err
 }
@@ -59,3 +90,98 @@ func RegisterFormatFactory(kind string, fn CreateFormatterFunc) {
 	}
 	formatterCreators[kind] = fn
 }
+
+// ContextFormatter is a Formatter which can encode the key-value pairs
+// bound with Logger.With once instead of on every entry.
+type ContextFormatter interface {
+	Formatter
+	// WithContext returns a new formatter which writes args with every
+	// entry. The receiver must not be modified.
+	WithContext(args []interface{}) Formatter
+}
+
+// NamedFormatter is a Formatter which can copy itself for a logger with a
+// different name. It is used by Logger.Named.
+type NamedFormatter interface {
+	Formatter
+	// WithName returns a new formatter for name which keeps any context of
+	// the receiver. The receiver must not be modified.
+	WithName(name string) Formatter
+}
+
+// contextFormatter binds key-value pairs to formatters which do not
+// implement ContextFormatter.
+type contextFormatter struct {
+	formatter Formatter
+	context   []interface{}
+	fields    []Field
+}
+
+func (cf *contextFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	args = balanceArgs(args)
+	all := make([]interface{}, 0, len(cf.context)+len(args))
+	all = append(all, cf.context...)
+	all = append(all, args...)
+	cf.formatter.Format(writer, level, msg, all)
+}
+
+// FormatEntry prepends the bound pairs to the fields of entry.
+func (cf *contextFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	ef, ok := cf.formatter.(EntryFormatter)
+	if !ok {
+		cf.Format(writer, entry.Level, entry.Message, entry.Args())
+		return
+	}
+	e := *entry
+	e.Fields = make([]Field, 0, len(cf.fields)+len(entry.Fields))
+	e.Fields = append(e.Fields, cf.fields...)
+	e.Fields = append(e.Fields, entry.Fields...)
+	if e.Stack == "" && hasError(cf.fields) {
+		e.Stack = string(debug.Stack())
+	}
+	ef.FormatEntry(writer, &e)
+}
+
+func (cf *contextFormatter) WithName(name string) Formatter {
+	return newContextFormatter(withName(cf.formatter, name), cf.context)
+}
+
+func newContextFormatter(formatter Formatter, context []interface{}) *contextFormatter {
+	return &contextFormatter{formatter: formatter, context: context, fields: argsToFields(context)}
+}
+
+// withName returns a formatter for name. Formatters which do not implement
+// NamedFormatter are shared as is.
+func withName(formatter Formatter, name string) Formatter {
+	if nf, ok := formatter.(NamedFormatter); ok {
+		return nf.WithName(name)
+	}
+	return formatter
+}
+
+// withContext returns a formatter which writes args with every entry.
+func withContext(formatter Formatter, args []interface{}) Formatter {
+	if cf, ok := formatter.(ContextFormatter); ok {
+		return cf.WithContext(args)
+	}
+
+	args = balanceArgs(args)
+	if cf, ok := formatter.(*contextFormatter); ok {
+		context := make([]interface{}, 0, len(cf.context)+len(args))
+		context = append(context, cf.context...)
+		context = append(context, args...)
+		return newContextFormatter(cf.formatter, context)
+	}
+	return newContextFormatter(formatter, args)
+}
+
+// balanceArgs returns args as key-value pairs keyed the same way formatters
+// log a single argument or imbalanced pairs.
+func balanceArgs(args []interface{}) []interface{} {
+	if len(args) == 1 {
+		return []interface{}{singleArgKey, args[0]}
+	} else if len(args)%2 != 0 {
+		return []interface{}{warnImbalancedKey, args}
+	}
+	return args
+}
@@ -0,0 +1,317 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GELFFormatter formats entries as GELF 1.1 JSON for Graylog. The message
+// is short_message, the error call stack is full_message and the level is
+// the syslog severity. The logger name, pid and key-value pairs are
+// additional fields prefixed with "_".
+type GELFFormatter struct {
+	name string
+	// Host defaults to os.Hostname.
+	Host string
+}
+
+// NewGELFFormatter creates a new GELFFormatter.
+func NewGELFFormatter(name string) *GELFFormatter {
+	hostname, _ := os.Hostname()
+	return &GELFFormatter{name: name, Host: hostname}
+}
+
+// WithName returns a copy of the formatter for name.
+func (gf *GELFFormatter) WithName(name string) Formatter {
+	return &GELFFormatter{name: name, Host: gf.Host}
+}
+
+// Format formats a log entry as GELF.
+func (gf *GELFFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	gf.FormatEntry(writer, NewEntry(level, gf.name, msg, args))
+}
+
+// FormatEntry formats entry as GELF terminated by a newline.
+func (gf *GELFFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	buf := pool.Get()
+	defer pool.Put(buf)
+
+	buf.WriteString(`{"version":"1.1","host":`)
+	valueFormatter.writeString(buf, gf.Host)
+	buf.WriteString(`,"short_message":`)
+	valueFormatter.writeString(buf, entry.Message)
+	if entry.Stack != "" && hasError(entry.Fields) {
+		buf.WriteString(`,"full_message":`)
+		valueFormatter.writeString(buf, entry.Stack)
+	}
+	buf.WriteString(`,"timestamp":`)
+	buf.WriteString(strconv.FormatInt(entry.Time.Unix(), 10))
+	buf.WriteByte('.')
+	ms := strconv.Itoa(entry.Time.Nanosecond() / 1e6)
+	buf.WriteString(strings.Repeat("0", 3-len(ms)) + ms)
+	buf.WriteString(`,"level":`)
+	buf.WriteString(strconv.Itoa(syslogSeverity(entry.Level)))
+
+	gf.set(buf, KeyMap.Name, gf.name)
+	gf.set(buf, KeyMap.PID, pid)
+	if entry.Caller != nil {
+		gf.set(buf, "file", entry.Caller.File)
+		gf.set(buf, "line", entry.Caller.Line)
+	}
+	for _, field := range entry.Fields {
+		gf.set(buf, field.Key, field.Value)
+	}
+	buf.WriteString("}\n")
+	buf.WriteTo(writer)
+}
+
+// set writes an additional field. Values are numbers or strings.
+func (gf *GELFFormatter) set(buf *bytes.Buffer, key string, value interface{}) {
+	buf.WriteString(`,"`)
+	buf.WriteString(gelfFieldName(key))
+	buf.WriteString(`":`)
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if _, ok := value.(fmt.Stringer); !ok {
+			valueFormatter.appendValue(buf, value)
+			return
+		}
+	}
+	valueFormatter.writeString(buf, valueString(value))
+}
+
+// gelfFieldName returns key as an additional field name, which starts with
+// "_" and has only word characters, '.' and '-'. "_id" is reserved.
+func gelfFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, key)
+	if !strings.HasPrefix(name, "_") {
+		name = "_" + name
+	}
+	if name == "_id" {
+		name = "_id_"
+	}
+	return name
+}
+
+// GELFCompression is the compression of GELF UDP messages.
+type GELFCompression int
+
+const (
+	// GELFCompressGzip compresses messages with gzip.
+	GELFCompressGzip GELFCompression = iota
+
+	// GELFCompressZlib compresses messages with zlib.
+	GELFCompressZlib
+
+	// GELFCompressNone does not compress messages.
+	GELFCompressNone
+)
+
+// gelfChunkSize is the default maximum size of a UDP datagram
+const gelfChunkSize = 1420
+
+// gelfMaxChunks is the maximum number of chunks of a message
+const gelfMaxChunks = 128
+
+// GELFWriter sends entries formatted by GELFFormatter to Graylog. Over
+// UDP, messages are compressed and split into chunks no larger than
+// ChunkSize. Over TCP, messages are uncompressed and delimited by a null
+// byte, and the connection is reestablished when a write fails. It is safe
+// for concurrent use.
+type GELFWriter struct {
+	network     string
+	addr        string
+	compression GELFCompression
+	// ChunkSize is the maximum size of UDP datagrams. It must be larger than
+	// the 12 byte chunk header. Set it before writing.
+	ChunkSize int
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+// NewGELFWriter connects to the GELF input at addr on network, which is
+// "udp" or "tcp". compression only applies to UDP.
+//
+// Example
+// writer, err := log.NewGELFWriter("udp", "graylog:12201", log.GELFCompressGzip)
+// logger := log.NewLogger3(writer, "app", log.NewGELFFormatter("app"))
+func NewGELFWriter(network, addr string, compression GELFCompression) (*GELFWriter, error) {
+	gw := &GELFWriter{
+		network:     network,
+		addr:        addr,
+		compression: compression,
+		ChunkSize:   gelfChunkSize,
+	}
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	gw.conn = conn
+	return gw, nil
+}
+
+// isStream reports whether messages are delimited by a null byte.
+func (gw *GELFWriter) isStream() bool {
+	return strings.HasPrefix(gw.network, "tcp")
+}
+
+// Write sends p as a GELF message without its trailing newline.
+func (gw *GELFWriter) Write(p []byte) (int, error) {
+	msg := bytes.TrimSuffix(p, []byte("\n"))
+
+	var err error
+	if gw.isStream() {
+		framed := make([]byte, 0, len(msg)+1)
+		framed = append(framed, msg...)
+		msg = append(framed, 0)
+	} else if msg, err = gw.compress(msg); err != nil {
+		return 0, err
+	}
+
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if gw.closed {
+		return 0, ErrWriterClosed
+	}
+
+	if gw.isStream() {
+		err = gw.writeStream(msg)
+	} else {
+		err = gw.writeChunks(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// compress compresses msg for UDP.
+func (gw *GELFWriter) compress(msg []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	switch gw.compression {
+	case GELFCompressGzip:
+		zw = gzip.NewWriter(&buf)
+	case GELFCompressZlib:
+		zw = zlib.NewWriter(&buf)
+	default:
+		return msg, nil
+	}
+	if _, err := zw.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeStream writes msg, reconnecting once if the write fails. mu must be
+// held.
+func (gw *GELFWriter) writeStream(msg []byte) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if gw.conn == nil {
+			if gw.conn, err = net.Dial(gw.network, gw.addr); err != nil {
+				gw.conn = nil
+				continue
+			}
+		}
+		if _, err = gw.conn.Write(msg); err == nil {
+			return nil
+		}
+		gw.conn.Close()
+		gw.conn = nil
+	}
+	return err
+}
+
+// writeChunks writes msg as one datagram or as chunks with the GELF chunk
+// header: magic bytes 0x1e 0x0f, an 8 byte message id, the sequence number
+// and the sequence count. mu must be held.
+func (gw *GELFWriter) writeChunks(msg []byte) error {
+	if len(msg) <= gw.ChunkSize {
+		_, err := gw.conn.Write(msg)
+		return err
+	}
+
+	const headerSize = 12
+	if gw.ChunkSize <= headerSize {
+		return fmt.Errorf("GELF ChunkSize %d is not larger than the %d byte chunk header", gw.ChunkSize, headerSize)
+	}
+	size := gw.ChunkSize - headerSize
+	count := (len(msg) + size - 1) / size
+	if count > gelfMaxChunks {
+		return fmt.Errorf("GELF message of %d bytes needs %d chunks, more than %d", len(msg), count, gelfMaxChunks)
+	}
+
+	chunk := make([]byte, 0, gw.ChunkSize)
+	chunk = append(chunk, 0x1e, 0x0f)
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	chunk = append(chunk, id[:]...)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(msg) {
+			end = len(msg)
+		}
+		chunk = append(chunk[:10], byte(i), byte(count))
+		chunk = append(chunk, msg[i*size:end]...)
+		if _, err := gw.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection. Subsequent writes return ErrWriterClosed.
+func (gw *GELFWriter) Close() error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if gw.closed {
+		return nil
+	}
+	gw.closed = true
+	if gw.conn == nil {
+		return nil
+	}
+	return gw.conn.Close()
+}
+
+// gelfSinkFactory opens a GELFWriter for LOGXI_SINKS targets like
+// "gelf://graylog:12201" for gzipped UDP and "gelf+tcp://graylog:12201" for
+// TCP.
+func gelfSinkFactory(target string) (io.Writer, error) {
+	idx := strings.Index(target, "://")
+	network := "udp"
+	if target[:idx] == "gelf+tcp" {
+		network = "tcp"
+	}
+	writer, err := NewGELFWriter(network, target[idx+3:], GELFCompressGzip)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
@@ -18,16 +18,22 @@ type colorScheme struct {
 	Misc    string
 	Source  string
 
-	Trace string
-	Debug string
-	Info  string
-	Warn  string
-	Error string
+	Trace     string
+	Debug     string
+	Info      string
+	Notice    string
+	Warn      string
+	Error     string
+	Fatal     string
+	Critical  string
+	Alert     string
+	Emergency string
+
+	// Levels are the colors of levels registered with RegisterLevel
+	Levels map[Level]string
 }
 
 var indent = "  "
-var maxCol = defaultMaxCol
-var theme *colorScheme
 
 func parseKVList(s, separator string) map[string]string {
 	pairs := strings.Split(s, separator)
@@ -95,6 +101,30 @@ func parseTheme(theme string) *colorScheme {
 	cs.Warn = color("WRN")
 	cs.Info = color("INF")
 	cs.Error = color("ERR")
+
+	// syslog levels default to the color of the nearest common level
+	var colorOr = func(key string, fallback string) string {
+		if _, ok := m[key]; ok {
+			return color(key)
+		}
+		return fallback
+	}
+	cs.Notice = colorOr("NTC", cs.Info)
+	cs.Fatal = colorOr("FTL", cs.Error)
+	cs.Critical = colorOr("CRT", cs.Error)
+	cs.Alert = colorOr("ALR", cs.Error)
+	cs.Emergency = colorOr("EMR", cs.Error)
+
+	cs.Levels = map[Level]string{}
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+	for level, li := range customLevels {
+		c := ""
+		if !disableColors {
+			c = ansi.ColorCode(li.color)
+		}
+		cs.Levels[level] = colorOr(li.short, c)
+	}
 	return cs
 }
 
@@ -103,9 +133,9 @@ func parseTheme(theme string) *colorScheme {
 // warnings and errors occur.
 //
 // HappyDevFormatter does not worry about performance. It's at least 3-4X
-// slower than JSONFormatter since it delegates to JSONFormatter to marshal
-// then unmarshal JSON. Then it does other stuff like read source files, sort
-// keys all to give a developer more information.
+// slower than JSONFormatter since it encodes values with JSONFormatter to
+// ensure they marshal in production. Then it does other stuff like read
+// source files all to give a developer more information.
 //
 // SHOULD NOT be used in production for extended period of time. However, it
 // works fine in SSH terminals and binary deployments.
@@ -114,6 +144,8 @@ type HappyDevFormatter struct {
 	col  int
 	// always use the production formatter
 	jsonFormatter *JSONFormatter
+	// context are the fields bound with Logger.With
+	context []Field
 }
 
 // NewHappyDevFormatter returns a new instance of HappyDevFormatter.
@@ -131,7 +163,7 @@ func (hd *HappyDevFormatter) writeKey(buf bufferWriter, key string) {
 	if key == "" {
 		return
 	}
-	buf.WriteString(theme.Key)
+	buf.WriteString(settings().theme.Key)
 	hd.writeString(buf, key)
 	hd.writeString(buf, AssignmentChar)
 	if !disableColors {
@@ -149,7 +181,8 @@ func (hd *HappyDevFormatter) set(buf bufferWriter, key string, value interface{}
 		str = fmt.Sprintf("%v", value)
 	}
 	val := strings.Trim(str, "\n ")
-	if (isPretty && key != "") || hd.col+len(key)+2+len(val) >= maxCol {
+	fs := settings()
+	if (fs.isPretty && key != "") || hd.col+len(key)+2+len(val) >= fs.maxCol {
 		buf.WriteString("\n")
 		hd.col = 0
 		hd.writeString(buf, indent)
@@ -171,59 +204,103 @@ func (hd *HappyDevFormatter) writeString(buf bufferWriter, s string) {
 	hd.col += len(s)
 }
 
-func (hd *HappyDevFormatter) getContext(color string) string {
-	if disableCallstack {
-		return ""
-	}
-	frames := parseDebugStack(string(debug.Stack()), 5, true)
-	if len(frames) == 0 {
-		return ""
+// valueFormatter encodes values for valueString
+var valueFormatter = &JSONFormatter{}
+
+// valueString returns the displayed value. Values other than strings and
+// errors are encoded by the production formatter, so Stringers are
+// displayed as they are logged in production.
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "<nil>"
+	case string:
+		return v
+	case error:
+		return v.Error()
 	}
-	for _, frame := range frames {
-		context := frame.String(color, theme.Source)
-		if context != "" {
-			return context
+
+	buf := pool.Get()
+	defer pool.Put(buf)
+	valueFormatter.appendValue(buf, value)
+	b := buf.Bytes()
+	if len(b) > 0 && b[0] == '"' {
+		var str string
+		if err := json.Unmarshal(b, &str); err == nil {
+			return str
 		}
 	}
-	return ""
+	return string(b)
 }
 
-func (hd *HappyDevFormatter) getLevelContext(level int, entry map[string]interface{}) (message string, context string, color string) {
+func (hd *HappyDevFormatter) getContext(entry *Entry, color string) string {
+	if disableCallstack || entry.Caller == nil {
+		return ""
+	}
+	frame := &frameInfo{
+		filename:     entry.Caller.File,
+		lineno:       entry.Caller.Line,
+		method:       entry.Caller.Function,
+		contextLines: -1,
+	}
+	return frame.String(color, settings().theme.Source)
+}
 
+func (hd *HappyDevFormatter) getLevelContext(entry *Entry, stack string) (message string, context string, color string) {
+	fs := settings()
+	theme := fs.theme
+	level := entry.Level
 	switch level {
 	case LevelTrace:
 		color = theme.Trace
-		context = hd.getContext(color)
-		context += "\n"
+		context = hd.getContext(entry, color)
+		if context != "" {
+			context += "\n"
+		}
 	case LevelDebug:
 		color = theme.Debug
 	case LevelInfo:
 		color = theme.Info
+	case LevelNotice:
+		color = theme.Notice
 	// case LevelWarn:
 	// 	color = theme.Warn
 	// 	context = hd.getContext(color)
 	// 	context += "\n"
-	case LevelWarn, LevelError, LevelFatal:
+	case LevelWarn, LevelError, LevelFatal, LevelCritical, LevelAlert, LevelEmergency:
 
 		// warnings return an error but if it does not have an error
 		// then print line info only
-		if level == LevelWarn {
+		switch level {
+		case LevelWarn:
 			color = theme.Warn
-			kv := entry[KeyMap.CallStack]
-			if kv == nil {
-				context = hd.getContext(color)
-				context += "\n"
-				break
+			if stack == "" {
+				context = hd.getContext(entry, color)
+				if context != "" {
+					context += "\n"
+				}
+				return message, context, color
 			}
-		} else {
+		case LevelFatal:
+			color = theme.Fatal
+		case LevelCritical:
+			color = theme.Critical
+		case LevelAlert:
+			color = theme.Alert
+		case LevelEmergency:
+			color = theme.Emergency
+		default:
 			color = theme.Error
 		}
 
-		if disableCallstack || contextLines == -1 {
-			context = trimDebugStack(string(debug.Stack()))
+		if disableCallstack || fs.contextLines == -1 {
+			if stack == "" {
+				stack = string(debug.Stack())
+			}
+			context = trimDebugStack(stack)
 			break
 		}
-		frames := parseLogxiStack(entry, 4, true)
+		frames := parseLogxiStack(stack, 4, true)
 		if frames == nil {
 			frames = parseDebugStack(string(debug.Stack()), 4, true)
 		}
@@ -235,7 +312,7 @@ func (hd *HappyDevFormatter) getLevelContext(level int, entry map[string]interfa
 		defer pool.Put(errbuf)
 		lines := 0
 		for _, frame := range frames {
-			err := frame.readSource(contextLines)
+			err := frame.readSource(fs.contextLines)
 			if err != nil {
 				// by setting to empty, the original stack is used
 				errbuf.Reset()
@@ -251,34 +328,23 @@ func (hd *HappyDevFormatter) getLevelContext(level int, entry map[string]interfa
 		}
 		context = errbuf.String()
 	default:
-		panic("should never get here")
+		// levels registered with RegisterLevel
+		color = theme.Levels[level]
 	}
 	return message, context, color
 }
 
-// Format a log entry.
-func (hd *HappyDevFormatter) Format(writer io.Writer, level int, msg string, args []interface{}) {
-	buf := pool.Get()
-	defer pool.Put(buf)
-
-	if len(args) == 1 {
-		args = append(args, 0)
-		copy(args[1:], args[0:])
-		args[0] = singleArgKey
-	}
-
-	// warn about reserved, bad and complex keys
-	for i := 0; i < len(args); i += 2 {
-		isReserved, err := isReservedKey(args[i])
-		if err != nil {
-			InternalLog.Error("Key is not a string.", "err", fmt.Errorf("args[%d]=%v", i, args[i]))
-		} else if isReserved {
-			InternalLog.Fatal("Key conflicts with reserved key. Avoiding using single rune keys.", "key", args[i].(string))
+// checkFields warns about reserved and complex keys.
+func (hd *HappyDevFormatter) checkFields(fields []Field) {
+	for _, field := range fields {
+		key := field.Key
+		isReserved, _ := isReservedKey(key)
+		if isReserved {
+			InternalLog.Error("Key conflicts with reserved key. Avoiding using single rune keys.", "key", key)
 		} else {
 			// Ensure keys are simple strings. The JSONFormatter doesn't escape
 			// keys as a performance tradeoff. This panics if the JSON key
 			// value has a different value than a simple quoted string.
-			key := args[i].(string)
 			b, err := json.Marshal(key)
 			if err != nil {
 				panic("Key is invalid. " + err.Error())
@@ -288,67 +354,95 @@ func (hd *HappyDevFormatter) Format(writer io.Writer, level int, msg string, arg
 			}
 		}
 	}
+}
+
+// WithContext returns a new HappyDevFormatter which writes the key-value
+// pairs args with every entry. Keys are checked once.
+func (hd *HappyDevFormatter) WithContext(args []interface{}) Formatter {
+	fields := argsToFields(args)
+	hd.checkFields(fields)
+
+	context := make([]Field, 0, len(hd.context)+len(fields))
+	context = append(context, hd.context...)
+	context = append(context, fields...)
+
+	return &HappyDevFormatter{
+		name:          hd.name,
+		jsonFormatter: hd.jsonFormatter,
+		context:       context,
+	}
+}
+
+// WithName returns a new HappyDevFormatter for name.
+func (hd *HappyDevFormatter) WithName(name string) Formatter {
+	return &HappyDevFormatter{
+		name:          name,
+		jsonFormatter: hd.jsonFormatter.WithName(name).(*JSONFormatter),
+		context:       hd.context,
+	}
+}
+
+// Format a log entry.
+func (hd *HappyDevFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	hd.FormatEntry(writer, NewEntry(level, hd.name, msg, args))
+}
 
-	// use the production JSON formatter to format the log first. This
-	// ensures JSON will marshal/unmarshal correctly in production.
-	entry := hd.jsonFormatter.LogEntry(level, msg, args)
+// FormatEntry formats entry.
+func (hd *HappyDevFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	buf := pool.Get()
+	defer pool.Put(buf)
+
+	hd.checkFields(entry.Fields)
+
+	stack := entry.Stack
+	if stack == "" && hasError(hd.context) {
+		stack = string(debug.Stack())
+	}
 
 	// reset the column tracker used for fancy formatting
 	hd.col = 0
+	fs := settings()
+	theme := fs.theme
 
 	// timestamp
 	buf.WriteString(theme.Misc)
-	hd.writeString(buf, entry[KeyMap.Time].(string))
+	hd.writeString(buf, entry.Time.Format(fs.timeFormat))
 	if !disableColors {
 		buf.WriteString(ansi.Reset)
 	}
 
 	// emphasize warnings and errors
-	message, context, color := hd.getLevelContext(level, entry)
+	message, context, color := hd.getLevelContext(entry, stack)
 	if message == "" {
-		message = entry[KeyMap.Message].(string)
+		message = entry.Message
 	}
 
 	// DBG, INF ...
-	hd.set(buf, "", entry[KeyMap.Level].(string), color)
+	hd.set(buf, "", entry.Level.String(), color)
 	// logger name
-	hd.set(buf, "", entry[KeyMap.Name], theme.Misc)
+	hd.set(buf, "", hd.name, theme.Misc)
 	// message from user
 	hd.set(buf, "", message, theme.Message)
 
-	// Preserve key order in the sequencethey were added by developer.This
+	// Preserve key order in the sequence they were added by developer. This
 	// makes it easier for developers to follow the log.
-	order := []string{}
-	lenArgs := len(args)
-	for i := 0; i < len(args); i += 2 {
-		if i+1 >= lenArgs {
-			continue
-		}
-		if key, ok := args[i].(string); ok {
-			order = append(order, key)
-		} else {
-			order = append(order, badKeyAtIndex(i))
-		}
-	}
-
-	for _, key := range order {
-		// skip reserved keys which were already added to buffer above
-		isReserved, err := isReservedKey(key)
-		if err != nil {
-			panic("key is invalid. Should never get here. " + err.Error())
-		} else if isReserved {
-			continue
+	for _, fields := range [][]Field{hd.context, entry.Fields} {
+		for _, field := range fields {
+			// skip reserved keys which were already added to buffer above
+			if isReserved, _ := isReservedKey(field.Key); isReserved {
+				continue
+			}
+			hd.set(buf, field.Key, valueString(field.Value), theme.Value)
 		}
-		hd.set(buf, key, entry[key], theme.Value)
 	}
 
 	addLF := true
-	hasCallStack := entry[KeyMap.CallStack] != nil
+	hasCallStack := stack != ""
 	// WRN,ERR file, line number context
 
 	if context != "" {
 		// warnings and traces are single line, space can be optimized
-		if level == LevelTrace || (level == LevelWarn && !hasCallStack) {
+		if entry.Level == LevelTrace || (entry.Level == LevelWarn && !hasCallStack) {
 			// gets rid of "in "
 			idx := strings.IndexRune(context, 'n')
 			hd.set(buf, "in", context[idx+2:], color)
@@ -364,7 +458,7 @@ func (hd *HappyDevFormatter) Format(writer io.Writer, level int, msg string, arg
 			}
 		}
 	} else if hasCallStack {
-		hd.set(buf, "", entry[KeyMap.CallStack], color)
+		hd.set(buf, "", stack, color)
 	}
 	if addLF {
 		buf.WriteRune('\n')
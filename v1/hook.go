@@ -0,0 +1,140 @@
+package log
+
+import "sync"
+
+// HookFunc processes an entry before it is formatted. It may modify the
+// entry, eg add a hostname field or rename keys, or observe it, eg send a
+// copy of errors to an incident channel. Return false to drop the entry.
+//
+// Hooks must not log to the logger which runs them.
+type HookFunc func(entry *Entry) bool
+
+type hook struct {
+	fn HookFunc
+	// levels are the levels the hook runs for or nil for all levels
+	levels map[Level]bool
+}
+
+// hookChain is an ordered list of hooks.
+type hookChain struct {
+	sync.RWMutex
+	hooks []*hook
+}
+
+func newHook(fn HookFunc, levels []Level) *hook {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	h := &hook{fn: fn}
+	if len(levels) > 0 {
+		h.levels = map[Level]bool{}
+		for _, level := range levels {
+			h.levels[level] = true
+		}
+	}
+	return h
+}
+
+func (hc *hookChain) add(h *hook) {
+	hc.Lock()
+	defer hc.Unlock()
+	hc.hooks = append(hc.hooks, h)
+}
+
+func (hc *hookChain) clear() {
+	hc.Lock()
+	defer hc.Unlock()
+	hc.hooks = nil
+}
+
+func (hc *hookChain) snapshot() []*hook {
+	hc.RLock()
+	defer hc.RUnlock()
+	return hc.hooks
+}
+
+// run runs the hooks in order of registration. It returns false if a hook
+// dropped the entry.
+func (hc *hookChain) run(entry *Entry) bool {
+	for _, h := range hc.snapshot() {
+		if h.levels != nil && !h.levels[entry.Level] {
+			continue
+		}
+		if !runHook(h, entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// runHook runs a hook. A panicking hook is reported to InternalLog and
+// keeps the entry.
+func runHook(h *hook, entry *Entry) (keep bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			InternalLog.Error("Hook panicked", "name", entry.Name, "err", r)
+			keep = true
+		}
+	}()
+	return h.fn(entry)
+}
+
+// globalHooks run for entries of all loggers before their own hooks
+var globalHooks = &hookChain{}
+
+// AddHook registers a hook which runs for entries of all loggers at levels,
+// or all levels if none are given. Global hooks run in order of
+// registration before the hooks of a logger.
+//
+// Example
+// hostname, _ := os.Hostname()
+// log.AddHook(func(entry *log.Entry) bool {
+//     entry.Fields = append(entry.Fields, log.Field{Key: "host", Value: hostname})
+//     return true
+// })
+func AddHook(fn HookFunc, levels ...Level) {
+	globalHooks.add(newHook(fn, levels))
+}
+
+// ClearHooks removes all global hooks.
+func ClearHooks() {
+	globalHooks.clear()
+}
+
+// AddHook registers a hook which runs for entries of this logger at levels,
+// or all levels if none are given. Child loggers created by With share the
+// hooks. Loggers created by Named inherit the hooks registered so far.
+//
+// Example
+// logger := log.New("server").(*log.DefaultLogger)
+// logger.AddHook(func(entry *log.Entry) bool {
+//     incidents <- *entry
+//     return true
+// }, log.LevelError)
+func (l *DefaultLogger) AddHook(fn HookFunc, levels ...Level) {
+	l.hooks.add(newHook(fn, levels))
+}
+
+// ClearHooks removes the hooks of this logger.
+func (l *DefaultLogger) ClearHooks() {
+	l.hooks.clear()
+}
+
+// hasHooks reports whether any hook may run for entries of this logger.
+func (l *DefaultLogger) hasHooks() bool {
+	// never run global hooks for the internal logger which reports panics
+	// of hooks
+	if l.name == "__logxi" {
+		return len(l.hooks.snapshot()) > 0
+	}
+	return len(globalHooks.snapshot()) > 0 || len(l.hooks.snapshot()) > 0
+}
+
+// runHooks runs the global hooks and the hooks of this logger. It returns
+// false if a hook dropped the entry.
+func (l *DefaultLogger) runHooks(entry *Entry) bool {
+	if l.name != "__logxi" && !globalHooks.run(entry) {
+		return false
+	}
+	return l.hooks.run(entry)
+}
@@ -39,17 +39,19 @@ var silent bool
 // internalLog is the logger used by logxi itself
 var InternalLog Logger
 
-type loggerMap struct {
+type loggerRegistry struct {
 	sync.Mutex
-	loggers map[string]Logger
+	// loggers are the registered loggers keyed by name. A logger replaces
+	// an earlier logger with the same name.
+	loggers map[string]*DefaultLogger
 }
 
-var loggers = &loggerMap{
-	loggers: map[string]Logger{},
+var loggers = &loggerRegistry{
+	loggers: map[string]*DefaultLogger{},
 }
 
-func (lm *loggerMap) set(name string, logger Logger) {
-	lm.loggers[name] = logger
+func (lr *loggerRegistry) add(logger *DefaultLogger) {
+	lr.loggers[logger.name] = logger
 }
 
 // The assignment character between key-value pairs
@@ -59,19 +61,20 @@ var AssignmentChar = ": "
 //var Separator = "{~}"
 var Separator = " "
 
-const ltsvAssignmentChar = ":"
-const ltsvSeparator = "\t"
-
 // logxiEnabledMap maps log name patterns to levels
-var logxiNameLevelMap map[string]int
+var logxiNameLevelMap map[string]Level
+
+// logxiLevelPatterns are the patterns of logxiNameLevelMap ordered from most
+// to least specific
+var logxiLevelPatterns []*levelPattern
 
-// logxiFormat is the formatter kind to create
-var logxiFormat string
+// logxiConfig is the configuration last processed by ProcessEnv
+var logxiConfig = &Configuration{}
 
 var colorableStdout io.Writer
 var defaultContextLines = 2
 var defaultFormat string
-var defaultLevel int
+var defaultLevel Level
 var defaultLogxiEnv string
 var defaultLogxiFormatEnv string
 var defaultMaxCol = 80
@@ -82,12 +85,10 @@ var disableCallstack bool
 var disableCheckKeys bool
 var disableColors bool
 var home string
-var isPretty bool
 var isTerminal bool
 var isWindows = runtime.GOOS == "windows"
 var pkgMutex sync.Mutex
 var pool = NewBufferPool()
-var timeFormat string
 var wd string
 var pid = os.Getpid()
 var pidStr = strconv.Itoa(os.Getpid())
@@ -116,7 +117,9 @@ var logxiKeys []string
 
 func setDefaults(isTerminal bool) {
 	var err error
-	contextLines = defaultContextLines
+	updateSettings(func(fs *formatSettings) {
+		fs.contextLines = defaultContextLines
+	})
 	wd, err = os.Getwd()
 	if err != nil {
 		InternalLog.Error("Could not get working directory")
@@ -193,6 +196,29 @@ func init() {
 	RegisterFormatFactory(FormatHappy, formatFactory)
 	RegisterFormatFactory(FormatText, formatFactory)
 	RegisterFormatFactory(FormatJSON, formatFactory)
+	RegisterFormatFactory(FormatSyslog, formatFactory)
+	RegisterFormatFactory(FormatSyslog3164, formatFactory)
+	RegisterFormatFactory(FormatJournald, formatFactory)
+	RegisterFormatFactory(FormatGELF, formatFactory)
+	RegisterFormatFactory(FormatLogfmt, formatFactory)
+	RegisterFormatFactory(FormatLTSV, formatFactory)
+	RegisterFormatFactory(FormatCSV, formatFactory)
+	RegisterFormatFactory(FormatTSV, formatFactory)
+	RegisterFormatFactory(FormatCBOR, formatFactory)
+	RegisterFormatFactory(FormatMsgpack, formatFactory)
+	RegisterFormatFactory(FormatOTel, formatFactory)
+	RegisterFormatFactory(FormatECS, formatFactory)
+	RegisterSinkFactory("file", fileSinkFactory)
+	RegisterSinkFactory("tcp", netSinkFactory)
+	RegisterSinkFactory("udp", netSinkFactory)
+	RegisterSinkFactory("unix", netSinkFactory)
+	RegisterSinkFactory("syslog", syslogSinkFactory)
+	RegisterSinkFactory("syslog+udp", syslogSinkFactory)
+	RegisterSinkFactory("syslog+tcp", syslogSinkFactory)
+	RegisterSinkFactory("syslog+unixgram", syslogSinkFactory)
+	RegisterSinkFactory("journald", journalSinkFactory)
+	RegisterSinkFactory("gelf", gelfSinkFactory)
+	RegisterSinkFactory("gelf+tcp", gelfSinkFactory)
 	ProcessEnv(readFromEnviron())
 
 	// package logger for users
@@ -0,0 +1,129 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// journalSocket is the socket of the journald native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// JournalFormatter formats entries in the journald native protocol:
+// MESSAGE, PRIORITY, SYSLOG_IDENTIFIER (the logger name), CODE_FILE,
+// CODE_LINE and CODE_FUNC of the caller of every entry, and the key-value
+// pairs with keys uppercased. Keys which are one of these fields are
+// prefixed with FIELD_. Use it with JournalWriter.
+type JournalFormatter struct {
+	name string
+}
+
+// NewJournalFormatter creates a new JournalFormatter.
+func NewJournalFormatter(name string) *JournalFormatter {
+	return &JournalFormatter{name: name}
+}
+
+// WithName returns a new JournalFormatter for name.
+func (jf *JournalFormatter) WithName(name string) Formatter {
+	return &JournalFormatter{name: name}
+}
+
+// NeedsCaller returns true as every entry has a code location.
+func (jf *JournalFormatter) NeedsCaller() bool {
+	return true
+}
+
+// Format formats a log entry for journald.
+func (jf *JournalFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	jf.FormatEntry(writer, NewEntry(level, jf.name, msg, args))
+}
+
+// FormatEntry formats entry for journald. Each entry is written at once so
+// JournalWriter sends it as a datagram.
+func (jf *JournalFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	buf := pool.Get()
+	defer pool.Put(buf)
+
+	writeJournalField(buf, "MESSAGE", entry.Message)
+	writeJournalField(buf, "PRIORITY", strconv.Itoa(syslogSeverity(entry.Level)))
+	if jf.name != "" {
+		writeJournalField(buf, "SYSLOG_IDENTIFIER", jf.name)
+	}
+	if entry.Caller != nil {
+		writeJournalField(buf, "CODE_FILE", entry.Caller.File)
+		writeJournalField(buf, "CODE_LINE", strconv.Itoa(entry.Caller.Line))
+		writeJournalField(buf, "CODE_FUNC", entry.Caller.Function)
+	}
+	for _, field := range entry.Fields {
+		writeJournalField(buf, journalFieldName(field.Key), valueString(field.Value))
+	}
+	buf.WriteTo(writer)
+}
+
+// writeJournalField writes KEY=value or, if value has newlines, KEY, a
+// newline, the little-endian 64-bit length of value and value.
+func writeJournalField(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	if strings.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	buf.WriteByte('\n')
+	buf.Write(size[:])
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalReserved are the fields written by JournalFormatter and the
+// syslog compatibility fields
+var journalReserved = map[string]bool{
+	"MESSAGE":           true,
+	"PRIORITY":          true,
+	"SYSLOG_IDENTIFIER": true,
+	"SYSLOG_FACILITY":   true,
+	"SYSLOG_PID":        true,
+	"CODE_FILE":         true,
+	"CODE_LINE":         true,
+	"CODE_FUNC":         true,
+}
+
+// journalFieldName returns key as a journal field name, which has up to 64
+// uppercase letters, digits and underscores and does not start with an
+// underscore or digit. Leading underscores are removed, eg "_suppressed"
+// is SUPPRESSED. Names of the fields written by JournalFormatter and those
+// starting with a digit are prefixed with FIELD_.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, key)
+	name = strings.TrimLeft(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') || journalReserved[name] {
+		name = "FIELD_" + name
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// journalSinkFactory opens a JournalWriter for LOGXI_SINKS targets like
+// "journald://" for the default socket or "journald:///path/to/socket".
+func journalSinkFactory(target string) (io.Writer, error) {
+	writer, err := NewJournalWriter(strings.TrimPrefix(target, "journald://"))
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
@@ -0,0 +1,160 @@
+//go:build linux
+// +build linux
+
+package log
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// JournalWriter sends entries formatted by JournalFormatter to journald
+// over its unix datagram socket. Entries too large for a datagram are
+// written to a sealed memfd, or an unlinked file in /dev/shm on kernels
+// without memfd, whose descriptor is sent instead. It is safe for
+// concurrent use.
+type JournalWriter struct {
+	addr *net.UnixAddr
+
+	mu     sync.Mutex
+	conn   *net.UnixConn
+	closed bool
+}
+
+// NewJournalWriter creates a writer for the journald socket at path. If
+// path is empty, the default socket /run/systemd/journal/socket is used.
+//
+// Example
+// writer, err := log.NewJournalWriter("")
+// logger := log.NewLogger3(writer, "app", log.NewJournalFormatter("app"))
+func NewJournalWriter(path string) (*JournalWriter, error) {
+	if path == "" {
+		path = journalSocket
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	// the socket is not connected so journald may restart
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournalWriter{
+		addr: &net.UnixAddr{Name: path, Net: "unixgram"},
+		conn: conn,
+	}, nil
+}
+
+// Write sends p, an entry formatted by JournalFormatter.
+func (jw *JournalWriter) Write(p []byte) (int, error) {
+	jw.mu.Lock()
+	defer jw.mu.Unlock()
+	if jw.closed {
+		return 0, ErrWriterClosed
+	}
+
+	_, _, err := jw.conn.WriteMsgUnix(p, nil, jw.addr)
+	if err == nil {
+		return len(p), nil
+	}
+	if !errors.Is(err, syscall.EMSGSIZE) && !errors.Is(err, syscall.ENOBUFS) {
+		return 0, err
+	}
+	if err := jw.writeFd(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFd writes p to a memfd or temporary file and sends its descriptor.
+func (jw *JournalWriter) writeFd(p []byte) error {
+	file, err := memfdCreate("logxi-journal")
+	sealed := err == nil
+	if err != nil {
+		if file, err = ioutil.TempFile("/dev/shm", "logxi-journal"); err != nil {
+			return err
+		}
+		os.Remove(file.Name())
+	}
+	defer file.Close()
+
+	if _, err := file.Write(p); err != nil {
+		return err
+	}
+	if sealed {
+		if err := sealMemfd(file); err != nil {
+			return err
+		}
+	}
+	rights := syscall.UnixRights(int(file.Fd()))
+	_, _, err = jw.conn.WriteMsgUnix([]byte{}, rights, jw.addr)
+	return err
+}
+
+// Close closes the socket. Subsequent writes return ErrWriterClosed.
+func (jw *JournalWriter) Close() error {
+	jw.mu.Lock()
+	defer jw.mu.Unlock()
+	if jw.closed {
+		return nil
+	}
+	jw.closed = true
+	return jw.conn.Close()
+}
+
+// memfdCreateTraps are the memfd_create syscall numbers which the syscall
+// package does not define on every architecture.
+var memfdCreateTraps = map[string]uintptr{
+	"386":      356,
+	"amd64":    319,
+	"arm":      385,
+	"arm64":    279,
+	"loong64":  279,
+	"mips":     4354,
+	"mipsle":   4354,
+	"mips64":   5314,
+	"mips64le": 5314,
+	"ppc64":    360,
+	"ppc64le":  360,
+	"riscv64":  279,
+	"s390x":    350,
+}
+
+const (
+	mfdCloexec      = 0x1
+	mfdAllowSealing = 0x2
+	fAddSeals       = 1033
+	// F_SEAL_SEAL | F_SEAL_SHRINK | F_SEAL_GROW | F_SEAL_WRITE
+	sealAll = 0x1 | 0x2 | 0x4 | 0x8
+)
+
+func memfdCreate(name string) (*os.File, error) {
+	trap, ok := memfdCreateTraps[runtime.GOARCH]
+	if !ok {
+		return nil, syscall.ENOSYS
+	}
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	fd, _, errno := syscall.Syscall(trap, uintptr(unsafe.Pointer(p)), mfdCloexec|mfdAllowSealing, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	return os.NewFile(fd, name), nil
+}
+
+// sealMemfd seals file as journald requires for memfds.
+func sealMemfd(file *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, file.Fd(), fAddSeals, sealAll)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
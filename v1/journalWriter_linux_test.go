@@ -0,0 +1,74 @@
+package log
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalWriter(t *testing.T) {
+	testResetEnv()
+	dir, err := ioutil.TempDir("", "logxi")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "journal.sock")
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	assert.NoError(t, err)
+	defer server.Close()
+
+	_, err = NewJournalWriter(filepath.Join(dir, "missing.sock"))
+	assert.Error(t, err)
+	jw, err := NewJournalWriter(sock)
+	assert.NoError(t, err)
+	defer jw.Close()
+	l := NewLogger3(jw, "app", NewJournalFormatter("app"))
+
+	buf := make([]byte, 1<<20)
+	oob := make([]byte, 1024)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	l.Error("small", "user", "gopher")
+	n, _, _, _, err := server.ReadMsgUnix(buf, oob)
+	assert.NoError(t, err)
+	fields := parseJournalFields(t, buf[:n])
+	assert.Equal(t, "small", fields["MESSAGE"])
+	assert.Equal(t, "3", fields["PRIORITY"])
+	assert.Equal(t, "gopher", fields["USER"])
+
+	// entries larger than a datagram are sent as a file descriptor
+	large := strings.Repeat("x", 4<<20)
+	l.Error("large", "data", large)
+	n, oobn, _, _, err := server.ReadMsgUnix(buf, oob)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(msgs))
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(fds))
+	link, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(fds[0]))
+	assert.NoError(t, err)
+	assert.Contains(t, link, "memfd:logxi-journal")
+	file := os.NewFile(uintptr(fds[0]), "journal")
+	defer file.Close()
+	// the offset is shared with the writer
+	_, err = file.Seek(0, 0)
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(file)
+	assert.NoError(t, err)
+	fields = parseJournalFields(t, b)
+	assert.Equal(t, "large", fields["MESSAGE"])
+	assert.True(t, large == fields["DATA"], "data is not equal")
+
+	assert.NoError(t, jw.Close())
+	_, err = jw.Write([]byte("MESSAGE=closed\n"))
+	assert.Equal(t, ErrWriterClosed, err)
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package log
+
+import "errors"
+
+// JournalWriter sends entries to journald. It is only supported on Linux.
+type JournalWriter struct{}
+
+// NewJournalWriter returns an error as journald is only supported on Linux.
+func NewJournalWriter(path string) (*JournalWriter, error) {
+	return nil, errors.New("journald is only supported on linux")
+}
+
+// Write returns an error.
+func (jw *JournalWriter) Write(p []byte) (int, error) {
+	return 0, ErrWriterClosed
+}
+
+// Close does nothing.
+func (jw *JournalWriter) Close() error {
+	return nil
+}
@@ -7,7 +7,6 @@ import (
 	"reflect"
 	"runtime/debug"
 	"strconv"
-	"time"
 )
 
 type bufferWriter interface {
@@ -26,6 +25,8 @@ type bufferWriter interface {
 // * sync.Pool buffer for bytes.Buffer
 type JSONFormatter struct {
 	name string
+	// context is the pre-encoded key-value pairs bound with Logger.With
+	context string
 }
 
 // NewJSONFormatter creates a new instance of JSONFormatter.
@@ -43,22 +44,15 @@ func (jf *JSONFormatter) writeString(buf bufferWriter, s string) {
 	buf.Write(b)
 }
 
-func (jf *JSONFormatter) writeError(buf bufferWriter, err error) {
-	jf.writeString(buf, err.Error())
-	jf.set(buf, KeyMap.CallStack, string(debug.Stack()))
-	return
-}
-
 func (jf *JSONFormatter) appendValue(buf bufferWriter, val interface{}) {
 	if val == nil {
 		buf.WriteString("null")
 		return
 	}
 
-	// always show error stack even at cost of some performance. there's
-	// nothing worse than looking at production logs without a clue
+	// the stack of errors is set by setFields
 	if err, ok := val.(error); ok {
-		jf.writeError(buf, err)
+		jf.writeString(buf, err.Error())
 		return
 	}
 
@@ -130,8 +124,45 @@ func (jf *JSONFormatter) set(buf bufferWriter, key string, val interface{}) {
 	jf.appendValue(buf, val)
 }
 
+// setFields writes fields. The stack is written after errors.
+func (jf *JSONFormatter) setFields(buf bufferWriter, fields []Field, stack string) {
+	for _, field := range fields {
+		jf.set(buf, field.Key, field.Value)
+		// always show error stack even at cost of some performance. there's
+		// nothing worse than looking at production logs without a clue
+		if _, ok := field.Value.(error); ok {
+			jf.set(buf, KeyMap.CallStack, stack)
+		}
+	}
+}
+
+// WithContext returns a new JSONFormatter which writes the pre-encoded
+// key-value pairs args with every entry.
+func (jf *JSONFormatter) WithContext(args []interface{}) Formatter {
+	buf := pool.Get()
+	defer pool.Put(buf)
+	buf.WriteString(jf.context)
+	fields := argsToFields(args)
+	var stack string
+	if hasError(fields) {
+		stack = string(debug.Stack())
+	}
+	jf.setFields(buf, fields, stack)
+	return &JSONFormatter{name: jf.name, context: buf.String()}
+}
+
+// WithName returns a new JSONFormatter for name.
+func (jf *JSONFormatter) WithName(name string) Formatter {
+	return &JSONFormatter{name: name, context: jf.context}
+}
+
 // Format formats log entry as JSON.
-func (jf *JSONFormatter) Format(writer io.Writer, level int, msg string, args []interface{}) {
+func (jf *JSONFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	jf.FormatEntry(writer, NewEntry(level, jf.name, msg, args))
+}
+
+// FormatEntry formats entry as JSON.
+func (jf *JSONFormatter) FormatEntry(writer io.Writer, entry *Entry) {
 	buf := pool.Get()
 	defer pool.Put(buf)
 
@@ -141,7 +172,7 @@ func (jf *JSONFormatter) Format(writer io.Writer, level int, msg string, args []
 	buf.WriteString(`{"`)
 	buf.WriteString(KeyMap.Time)
 	buf.WriteString(`":"`)
-	buf.WriteString(time.Now().Format(timeFormat))
+	buf.WriteString(entry.Time.Format(settings().timeFormat))
 
 	buf.WriteString(`", "`)
 	buf.WriteString(KeyMap.PID)
@@ -151,7 +182,7 @@ func (jf *JSONFormatter) Format(writer io.Writer, level int, msg string, args []
 	buf.WriteString(`", "`)
 	buf.WriteString(KeyMap.Level)
 	buf.WriteString(`":"`)
-	buf.WriteString(LevelMap[level])
+	buf.WriteString(entry.Level.String())
 
 	buf.WriteString(`", "`)
 	buf.WriteString(KeyMap.Name)
@@ -161,38 +192,16 @@ func (jf *JSONFormatter) Format(writer io.Writer, level int, msg string, args []
 	buf.WriteString(`", "`)
 	buf.WriteString(KeyMap.Message)
 	buf.WriteString(`":`)
-	jf.appendValue(buf, msg)
-
-	var lenArgs = len(args)
-	if lenArgs > 0 {
-		if lenArgs == 1 {
-			jf.set(buf, singleArgKey, args[0])
-		} else if lenArgs%2 == 0 {
-			for i := 0; i < lenArgs; i += 2 {
-				if key, ok := args[i].(string); ok {
-					if key == "" {
-						// show key is invalid
-						jf.set(buf, badKeyAtIndex(i), args[i+1])
-					} else {
-						jf.set(buf, key, args[i+1])
-					}
-				} else {
-					// show key is invalid
-					jf.set(buf, badKeyAtIndex(i), args[i+1])
-				}
-			}
-		} else {
-			jf.set(buf, warnImbalancedKey, args)
-		}
-	}
+	jf.appendValue(buf, entry.Message)
+
+	buf.WriteString(jf.context)
+	jf.setFields(buf, entry.Fields, entry.Stack)
 	buf.WriteString("}\n")
 	buf.WriteTo(writer)
 }
 
-// LogEntry returns the JSON log entry object built by Format(). Used by
-// HappyDevFormatter to ensure any data logged while developing properly
-// logs in production.
-func (jf *JSONFormatter) LogEntry(level int, msg string, args []interface{}) map[string]interface{} {
+// LogEntry returns the JSON log entry object built by Format().
+func (jf *JSONFormatter) LogEntry(level Level, msg string, args []interface{}) map[string]interface{} {
 	buf := pool.Get()
 	defer pool.Put(buf)
 	jf.Format(buf, level, msg, args)
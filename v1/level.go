@@ -0,0 +1,205 @@
+package log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Level is the severity of a log entry. Lower levels are more severe. A
+// logger logs entries whose level is less than or equal to its own level.
+type Level int
+
+// levelInfo describes a registered level.
+type levelInfo struct {
+	level Level
+	name  string
+	short string
+	color string
+}
+
+// builtinLevels are the syslog severities plus fatal and trace.
+var builtinLevels = []*levelInfo{
+	{level: LevelFatal, name: "fatal", short: "FTL"},
+	{level: LevelEmergency, name: "emergency", short: "EMR"},
+	{level: LevelAlert, name: "alert", short: "ALR"},
+	{level: LevelCritical, name: "critical", short: "CRT"},
+	{level: LevelError, name: "error", short: "ERR"},
+	{level: LevelWarn, name: "warn", short: "WRN"},
+	{level: LevelNotice, name: "notice", short: "NTC"},
+	{level: LevelInfo, name: "info", short: "INF"},
+	{level: LevelDebug, name: "debug", short: "DBG"},
+	{level: LevelTrace, name: "trace", short: "TRC"},
+}
+
+// customLevels are the levels registered with RegisterLevel.
+var customLevels = map[Level]*levelInfo{}
+
+// levelsMutex guards customLevels, LevelMap and LevelAtoi which are
+// modified by RegisterLevel.
+var levelsMutex sync.RWMutex
+
+// LevelMap maps levels to their short names, eg LevelDebug => "DBG". Use
+// RegisterLevel to add levels and Level.String to look up names.
+var LevelMap = func() map[Level]string {
+	m := map[Level]string{}
+	for _, li := range builtinLevels {
+		m[li.level] = li.short
+	}
+	return m
+}()
+
+// LevelAtoi maps short and long names of levels to levels, eg "DBG" and
+// "debug" => LevelDebug. Use ParseLevel to look up levels.
+var LevelAtoi = func() map[string]Level {
+	m := map[string]Level{
+		"OFF": LevelOff,
+		"ALL": LevelAll,
+		"off": LevelOff,
+		"all": LevelAll,
+	}
+	for _, li := range builtinLevels {
+		m[li.short] = li.level
+		m[li.name] = li.level
+	}
+	return m
+}()
+
+// String returns the short name of the level, eg "DBG".
+func (level Level) String() string {
+	switch level {
+	case LevelOff:
+		return "OFF"
+	case LevelAll:
+		return "ALL"
+	}
+	levelsMutex.RLock()
+	short, ok := LevelMap[level]
+	levelsMutex.RUnlock()
+	if ok {
+		return short
+	}
+	return "Level(" + strconv.Itoa(int(level)) + ")"
+}
+
+// levelName returns the long name of the level, eg "debug".
+func levelName(level Level) string {
+	for _, li := range builtinLevels {
+		if li.level == level {
+			return li.name
+		}
+	}
+	levelsMutex.RLock()
+	li, ok := customLevels[level]
+	levelsMutex.RUnlock()
+	if ok {
+		return li.name
+	}
+	return strings.ToLower(level.String())
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (level Level) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (level *Level) UnmarshalText(text []byte) error {
+	l, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*level = l
+	return nil
+}
+
+// Set implements flag.Value.
+//
+// Example
+// level := log.LevelWarn
+// flag.Var(&level, "level", "log level")
+func (level *Level) Set(s string) error {
+	return level.UnmarshalText([]byte(s))
+}
+
+// ParseLevel parses the short or long name of a level, eg "DBG" or
+// "debug", or its number. LevelOff is returned with the error if s is not
+// a level.
+func ParseLevel(s string) (Level, error) {
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+	if level, ok := LevelAtoi[s]; ok {
+		return level, nil
+	}
+	if level, ok := LevelAtoi[strings.ToUpper(s)]; ok {
+		return level, nil
+	}
+	if level, ok := LevelAtoi[strings.ToLower(s)]; ok {
+		return level, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return Level(n), nil
+	}
+	return LevelOff, fmt.Errorf("Unknown level %q", s)
+}
+
+// RegisterLevel registers a custom level, eg AUDIT. The name and short
+// name may be used in LOGXI and the short name is logged by formatters
+// and is the LOGXI_COLORS key. The severity must not be used by another
+// level. Built-in levels use -2, -1, 1-7 and 10. Use a severity below
+// LevelFatal for levels which are logged unless the logger is off.
+// Register levels before logging, eg in init().
+//
+// Example
+// const LevelAudit log.Level = -3
+// log.RegisterLevel("audit", "AUD", LevelAudit, "magenta+h")
+func RegisterLevel(name, short string, severity Level, color string) {
+	if name == "" || short == "" {
+		panic("name is empty string")
+	}
+	if severity <= LevelOff || severity >= LevelAll {
+		panic("severity is out of range: " + strconv.Itoa(int(severity)))
+	}
+
+	levelsMutex.Lock()
+	if _, ok := LevelMap[severity]; ok {
+		levelsMutex.Unlock()
+		panic("severity is already registered: " + strconv.Itoa(int(severity)))
+	}
+	for _, key := range []string{name, short} {
+		if _, ok := LevelAtoi[key]; ok {
+			levelsMutex.Unlock()
+			panic("level name is already registered: " + key)
+		}
+	}
+	customLevels[severity] = &levelInfo{level: severity, name: name, short: short, color: color}
+	LevelMap[severity] = short
+	LevelAtoi[short] = severity
+	LevelAtoi[name] = severity
+	levelsMutex.Unlock()
+
+	// refresh the theme with the color of the new level
+	pkgMutex.Lock()
+	ProcessLogxiColorsEnv(logxiConfig.Colors)
+	pkgMutex.Unlock()
+}
+
+// atomicLevel is the level of a logger which may be read while the
+// configuration is reloaded on another goroutine.
+type atomicLevel struct {
+	value int32
+}
+
+func newAtomicLevel(level Level) *atomicLevel {
+	return &atomicLevel{value: int32(level)}
+}
+
+func (al *atomicLevel) get() Level {
+	return Level(atomic.LoadInt32(&al.value))
+}
+
+func (al *atomicLevel) set(level Level) {
+	atomic.StoreInt32(&al.value, int32(level))
+}
@@ -0,0 +1,187 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strconv"
+	"unicode/utf8"
+)
+
+// LogfmtFormatter formats entries as logfmt, eg
+//
+//     _t=2016-01-02T03:04:05-0700 _p=123 _n=app _l=ERR _m="Could not save" user="go pher" err=boom _c="goroutine 1 [running]:\n..."
+//
+// Keys and values with spaces, '=', '"' or control characters are quoted
+// and escaped, so each entry is one line which ParseLogfmt parses.
+type LogfmtFormatter struct {
+	name string
+	// context is the pre-encoded key-value pairs bound with Logger.With
+	context string
+}
+
+// NewLogfmtFormatter creates a new LogfmtFormatter.
+func NewLogfmtFormatter(name string) *LogfmtFormatter {
+	return &LogfmtFormatter{name: name}
+}
+
+// WithContext returns a new LogfmtFormatter which writes the pre-encoded
+// key-value pairs args with every entry.
+func (lf *LogfmtFormatter) WithContext(args []interface{}) Formatter {
+	buf := pool.Get()
+	defer pool.Put(buf)
+	buf.WriteString(lf.context)
+	fields := argsToFields(args)
+	var stack string
+	if hasError(fields) {
+		stack = string(debug.Stack())
+	}
+	lf.setFields(buf, fields, stack)
+	return &LogfmtFormatter{name: lf.name, context: buf.String()}
+}
+
+// WithName returns a new LogfmtFormatter for name.
+func (lf *LogfmtFormatter) WithName(name string) Formatter {
+	return &LogfmtFormatter{name: name, context: lf.context}
+}
+
+// Format formats a log entry as logfmt.
+func (lf *LogfmtFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	lf.FormatEntry(writer, NewEntry(level, lf.name, msg, args))
+}
+
+// FormatEntry formats entry as a logfmt line.
+func (lf *LogfmtFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	buf := pool.Get()
+	defer pool.Put(buf)
+
+	writeLogfmtString(buf, KeyMap.Time)
+	buf.WriteByte('=')
+	writeLogfmtString(buf, entry.Time.Format(settings().timeFormat))
+	lf.set(buf, KeyMap.PID, pidStr)
+	lf.set(buf, KeyMap.Name, lf.name)
+	lf.set(buf, KeyMap.Level, entry.Level.String())
+	lf.set(buf, KeyMap.Message, entry.Message)
+	buf.WriteString(lf.context)
+	lf.setFields(buf, entry.Fields, entry.Stack)
+	buf.WriteByte('\n')
+	buf.WriteTo(writer)
+}
+
+func (lf *LogfmtFormatter) set(buf bufferWriter, key string, value string) {
+	buf.WriteString(" ")
+	writeLogfmtString(buf, key)
+	buf.WriteString("=")
+	writeLogfmtString(buf, value)
+}
+
+// setFields writes fields. The stack is written after errors.
+func (lf *LogfmtFormatter) setFields(buf bufferWriter, fields []Field, stack string) {
+	for _, field := range fields {
+		lf.set(buf, field.Key, valueString(field.Value))
+		if _, ok := field.Value.(error); ok {
+			lf.set(buf, KeyMap.CallStack, stack)
+		}
+	}
+}
+
+// writeLogfmtString writes s, quoted and escaped if needed.
+func writeLogfmtString(buf bufferWriter, s string) {
+	if needsLogfmtQuotes(s) {
+		buf.WriteString(strconv.Quote(s))
+		return
+	}
+	buf.WriteString(s)
+}
+
+// needsLogfmtQuotes reports whether s is empty or has spaces, '=', '"',
+// control characters or invalid UTF-8.
+func needsLogfmtQuotes(s string) bool {
+	if s == "" {
+		return true
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+				return true
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return true
+		}
+		i += size
+	}
+	return false
+}
+
+// ParseLogfmt parses a logfmt line into fields in order. Values are
+// unquoted strings, or nil for keys without '='.
+//
+// Example
+// fields, err := log.ParseLogfmt(`_l=ERR _m="Could not save" user=gopher`)
+func ParseLogfmt(line string) ([]Field, error) {
+	var fields []Field
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t' || line[i] == '\n' || line[i] == '\r') {
+			i++
+		}
+		if i == len(line) {
+			return fields, nil
+		}
+
+		key, next, err := scanLogfmtToken(line, i)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return nil, fmt.Errorf("logfmt: empty key at %d", i)
+		}
+		i = next
+		if i == len(line) || line[i] != '=' {
+			fields = append(fields, Field{Key: key})
+			continue
+		}
+
+		i++
+		value, next, err := scanLogfmtToken(line, i)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, Field{Key: key, Value: value})
+		i = next
+	}
+}
+
+// scanLogfmtToken scans a bare or quoted key or value starting at i. It
+// returns the unquoted token and the index after it.
+func scanLogfmtToken(line string, i int) (string, int, error) {
+	if i < len(line) && line[i] == '"' {
+		for j := i + 1; j < len(line); j++ {
+			switch line[j] {
+			case '\\':
+				j++
+			case '"':
+				s, err := strconv.Unquote(line[i : j+1])
+				if err != nil {
+					return "", 0, fmt.Errorf("logfmt: invalid quoted string at %d: %s", i, err)
+				}
+				return s, j + 1, nil
+			}
+		}
+		return "", 0, fmt.Errorf("logfmt: unterminated quoted string at %d", i)
+	}
+
+	j := i
+	for j < len(line) && line[j] > ' ' && line[j] != '=' && line[j] != '"' {
+		j++
+	}
+	if j < len(line) && line[j] == '"' {
+		return "", 0, fmt.Errorf("logfmt: unexpected '\"' at %d", j)
+	}
+	return line[i:j], j, nil
+}
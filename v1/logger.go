@@ -49,46 +49,47 @@ Code	Severity	Keyword
 const (
 	// LevelEnv chooses level from LOGXI environment variable or defaults
 	// to LevelInfo
-	LevelEnv = -10000
+	LevelEnv Level = -10000
 
 	// LevelOff means logging is disabled for logger. This should always
 	// be first
-	LevelOff = -1000
+	LevelOff Level = -1000
+
+	// LevelFatal means the application cannot continue. Fatal entries
+	// are logged unless the logger is off.
+	LevelFatal Level = -2
 
 	// LevelEmergency is usually 0 but that is also the "zero" value
 	// for Go, which means whenever we do any lookup in string -> int
 	// map 0 is returned (not good).
-	LevelEmergency = -1
+	LevelEmergency Level = -1
 
 	// LevelAlert means action must be taken immediately.
-	LevelAlert = 1
-
-	// LevelFatal means it should be corrected immediately, eg cannot connect to database.
-	LevelFatal = 2
+	LevelAlert Level = 1
 
-	// LevelCritical is alias for LevelFatal
-	LevelCritical = 2
+	// LevelCritical means it should be corrected immediately, eg cannot connect to database.
+	LevelCritical Level = 2
 
 	// LevelError is a non-urgen failure to notify devlopers or admins
-	LevelError = 3
+	LevelError Level = 3
 
 	// LevelWarn indiates an error will occur if action is not taken, eg file system 85% full
-	LevelWarn = 4
+	LevelWarn Level = 4
 
 	// LevelNotice is normal but significant condition.
-	LevelNotice = 5
+	LevelNotice Level = 5
 
 	// LevelInfo is info level
-	LevelInfo = 6
+	LevelInfo Level = 6
 
 	// LevelDebug is debug level
-	LevelDebug = 7
+	LevelDebug Level = 7
 
 	// LevelTrace is trace level and displays file and line in terminal
-	LevelTrace = 10
+	LevelTrace Level = 10
 
 	// LevelAll is all levels
-	LevelAll = 1000
+	LevelAll Level = 1000
 )
 
 // FormatHappy uses HappyDevFormatter
@@ -100,54 +101,67 @@ const FormatText = "text"
 // FormatJSON uses JSONFormatter
 const FormatJSON = "JSON"
 
-// FormatEnv selects formatter based on LOGXI_FORMAT environment variable
-const FormatEnv = ""
+// FormatSyslog uses SyslogFormatter
+const FormatSyslog = "syslog"
 
-// LevelMap maps int enums to string level.
-var LevelMap = map[int]string{
-	LevelFatal: "FTL",
-	LevelError: "ERR",
-	LevelWarn:  "WRN",
-	LevelInfo:  "INF",
-	LevelDebug: "DBG",
-	LevelTrace: "TRC",
-}
+// FormatSyslog3164 uses SyslogFormatter with RFC3164
+const FormatSyslog3164 = "rfc3164"
 
-// LevelMap maps int enums to string level.
-var LevelAtoi = map[string]int{
-	"OFF": LevelOff,
-	"FTL": LevelFatal,
-	"ERR": LevelError,
-	"WRN": LevelWarn,
-	"INF": LevelInfo,
-	"DBG": LevelDebug,
-	"TRC": LevelTrace,
-	"ALL": LevelAll,
-
-	"off":   LevelOff,
-	"fatal": LevelFatal,
-	"error": LevelError,
-	"warn":  LevelWarn,
-	"info":  LevelInfo,
-	"debug": LevelDebug,
-	"trace": LevelTrace,
-	"all":   LevelAll,
-}
+// FormatJournald uses JournalFormatter
+const FormatJournald = "journald"
+
+// FormatGELF uses GELFFormatter
+const FormatGELF = "gelf"
+
+// FormatLogfmt uses LogfmtFormatter
+const FormatLogfmt = "logfmt"
+
+// FormatLTSV uses LTSVFormatter
+const FormatLTSV = "LTSV"
+
+// FormatCSV uses ColumnFormatter with CSV rows
+const FormatCSV = "csv"
+
+// FormatTSV uses ColumnFormatter with TSV rows
+const FormatTSV = "tsv"
+
+// FormatCBOR uses BinaryFormatter with CBOR
+const FormatCBOR = "cbor"
+
+// FormatMsgpack uses BinaryFormatter with MessagePack
+const FormatMsgpack = "msgpack"
+
+// FormatOTel uses OTelFormatter
+const FormatOTel = "otel"
+
+// FormatECS uses ECSFormatter
+const FormatECS = "ecs"
+
+// FormatEnv selects formatter based on LOGXI_FORMAT environment variable
+const FormatEnv = ""
 
 // Logger is the interface for logging.
 type Logger interface {
 	Trace(msg string, args ...interface{})
 	Debug(msg string, args ...interface{})
 	Info(msg string, args ...interface{})
+	Notice(msg string, args ...interface{})
 	Warn(msg string, args ...interface{}) error
 	Error(msg string, args ...interface{}) error
+	Critical(msg string, args ...interface{}) error
+	Alert(msg string, args ...interface{}) error
+	Emergency(msg string, args ...interface{}) error
 	Fatal(msg string, args ...interface{})
-	Log(level int, msg string, args []interface{})
+	Log(level Level, msg string, args []interface{})
+	With(args ...interface{}) Logger
+	Named(sub string) Logger
 
-	SetLevel(int)
+	SetLevel(Level)
 	IsTrace() bool
 	IsDebug() bool
 	IsInfo() bool
+	IsNotice() bool
 	IsWarn() bool
-	// Error, Fatal not needed, those SHOULD always be logged
+	IsError() bool
+	// Critical, Alert, Emergency, Fatal not needed, those SHOULD always be logged
 }
@@ -1,13 +1,33 @@
 package log
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -79,22 +99,35 @@ func TestEnvLOGXI_FORMAT(t *testing.T) {
 	os.Setenv("LOGXI_FORMAT", "")
 	setDefaults(true)
 	processEnv()
-	assert.Equal(FormatHappy, logxiFormat, "terminal defaults to FormatHappy")
+	assert.Equal(FormatHappy, settings().format, "terminal defaults to FormatHappy")
 	setDefaults(false)
 	processEnv()
-	assert.Equal(FormatJSON, logxiFormat, "non terminal defaults to FormatJSON")
+	assert.Equal(FormatJSON, settings().format, "non terminal defaults to FormatJSON")
 
 	os.Setenv("LOGXI_FORMAT", "JSON")
 	processEnv()
-	assert.Equal(FormatJSON, logxiFormat)
+	assert.Equal(FormatJSON, settings().format)
 
 	os.Setenv("LOGXI_FORMAT", "json")
 	setDefaults(true)
 	processEnv()
-	assert.Equal(FormatHappy, logxiFormat, "Mismatches defaults to FormatHappy")
+	assert.Equal(FormatHappy, settings().format, "Mismatches defaults to FormatHappy")
 	setDefaults(false)
 	processEnv()
-	assert.Equal(FormatJSON, logxiFormat, "Mismatches defaults to FormatJSON non terminal")
+	assert.Equal(FormatJSON, settings().format, "Mismatches defaults to FormatJSON non terminal")
+
+	// removed keys are reset on reload
+	os.Setenv("LOGXI_FORMAT", "happy,pretty,maxcol=40,context=5")
+	processEnv()
+	assert.True(settings().isPretty)
+	assert.Equal(40, settings().maxCol)
+	assert.Equal(5, settings().contextLines)
+	os.Setenv("LOGXI_FORMAT", "happy")
+	processEnv()
+	assert.Equal(defaultPretty, settings().isPretty)
+	assert.Equal(defaultMaxCol, settings().maxCol)
+	assert.Equal(defaultContextLines, settings().contextLines)
+	os.Setenv("LOGXI_FORMAT", "")
 
 	isTerminal = oldIsTerminal
 	setDefaults(isTerminal)
@@ -222,9 +255,25 @@ func TestKeyNotString(t *testing.T) {
 	l := NewLogger3(&buf, "badkey", NewHappyDevFormatter("badkey"))
 	l.SetLevel(LevelDebug)
 	l.Debug("foo", 1)
-	assert.Panics(t, func() {
+	buf.Reset()
+	assert.NotPanics(t, func() {
 		l.Debug("reserved key", "_t", "trying to use time")
 	})
+	assert.NotContains(t, buf.String(), "trying to use time", "reserved keys are skipped")
+}
+
+func TestHappyDevValues(t *testing.T) {
+	testResetEnv()
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "values", NewHappyDevFormatter("values"))
+	l.SetLevel(LevelDebug)
+	at := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	l.Info("values", "level", LevelWarn, "at", at, "n", 42)
+	out := regexp.MustCompile("\x1b\\[[0-9;]*m").ReplaceAllString(buf.String(), "")
+	// values are displayed as the production formatter encodes them
+	assert.Contains(t, out, "level: 4")
+	assert.Contains(t, out, "at: "+at.String())
+	assert.Contains(t, out, "n: 42")
 }
 
 func TestWarningErrorContext(t *testing.T) {
@@ -356,4 +405,2253 @@ func TestStringerNullPointers(t *testing.T) {
 	l.SetLevel(LevelDebug)
 	l.Info("info", "f", f)
 	assert.Contains(t, buf.String(), "null")
-}
\ No newline at end of file
+}
+func TestWith(t *testing.T) {
+	testResetEnv()
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "with", NewJSONFormatter("with"))
+	l.SetLevel(LevelDebug)
+	child := l.With("reqID", "abc", "user", 1)
+	child.Info("hello", "foo", "bar")
+
+	var obj map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", obj["reqID"])
+	assert.Equal(t, float64(1), obj["user"])
+	assert.Equal(t, "bar", obj["foo"])
+	assert.Equal(t, "with", obj[KeyMap.Name])
+
+	// parent is not affected
+	buf.Reset()
+	l.Info("parent")
+	obj = nil
+	err = json.Unmarshal(buf.Bytes(), &obj)
+	assert.NoError(t, err)
+	assert.Nil(t, obj["reqID"])
+
+	// grandchild accumulates pairs
+	buf.Reset()
+	child.With("tenant", "acme").Info("grandchild", 1)
+	obj = nil
+	err = json.Unmarshal(buf.Bytes(), &obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", obj["reqID"])
+	assert.Equal(t, "acme", obj["tenant"])
+	assert.Equal(t, float64(1), obj["_"])
+
+	// level is shared with parent
+	l.SetLevel(LevelError)
+	assert.False(t, child.IsInfo())
+	child.SetLevel(LevelDebug)
+	assert.True(t, l.IsDebug())
+
+	buf.Reset()
+	l = NewLogger3(&buf, "with", NewTextFormatter("with"))
+	l.SetLevel(LevelDebug)
+	l.With("reqID", "abc").Info("hello", "foo", "bar")
+	assert.True(t, strings.HasSuffix(buf.String(), "hello reqID: abc foo: bar\n"))
+
+	buf.Reset()
+	l = NewLogger3(&buf, "with", NewHappyDevFormatter("with"))
+	l.SetLevel(LevelDebug)
+	l.With("reqID", "abc").Info("hello", "foo", "bar")
+	out := buf.String()
+	assert.Contains(t, out, "abc")
+	assert.True(t, strings.Index(out, "reqID") < strings.Index(out, "foo"), "bound keys come first")
+	assert.NotPanics(t, func() {
+		l.With("_t", "reserved")
+	})
+
+	assert.Exactly(t, NullLog, NullLog.With("foo", "bar"))
+}
+
+type testContextKey string
+
+func TestContext(t *testing.T) {
+	testResetEnv()
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "ctx", NewJSONFormatter("ctx"))
+	l.SetLevel(LevelError)
+
+	reqKey := testContextKey("reqID")
+	RegisterContextKey(reqKey, "reqID")
+	defer func() {
+		contextKeys.Lock()
+		contextKeys.keys = nil
+		contextKeys.Unlock()
+	}()
+
+	ctx := NewContext(context.Background(), l)
+	assert.Exactly(t, l, FromContext(ctx))
+	assert.Exactly(t, DefaultLog, FromContext(context.Background()))
+
+	ctx = context.WithValue(ctx, reqKey, "abc")
+	InfoCtx(ctx, "filtered")
+	assert.Equal(t, 0, buf.Len())
+
+	ErrorCtx(ctx, "hello", "foo", "bar")
+	var obj map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", obj["reqID"])
+	assert.Equal(t, "bar", obj["foo"])
+
+	// per-request level override
+	buf.Reset()
+	dbgCtx := NewLevelContext(ctx, LevelDebug)
+	FromContext(dbgCtx).(ContextLogger).DebugCtx(dbgCtx, "debug", 1)
+	obj = nil
+	err = json.Unmarshal(buf.Bytes(), &obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", obj["reqID"])
+	assert.Equal(t, float64(1), obj["_"])
+	assert.False(t, l.IsDebug())
+
+	ErrorDummy := errors.New("dummy error")
+	cl := l.(ContextLogger)
+	assert.Exactly(t, ErrorDummy, cl.WarnCtx(dbgCtx, "warn", "err", ErrorDummy))
+	assert.NoError(t, cl.WarnCtx(ctx, "warn", "err", ErrorDummy))
+
+	buf.Reset()
+	assert.Exactly(t, ErrorDummy, cl.CriticalCtx(ctx, "critical", "err", ErrorDummy))
+	obj = nil
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &obj))
+	assert.Equal(t, "CRT", obj[KeyMap.Level])
+	assert.Equal(t, "abc", obj["reqID"])
+
+	buf.Reset()
+	NoticeCtx(NewLevelContext(ctx, LevelNotice), "notice")
+	obj = nil
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &obj))
+	assert.Equal(t, "NTC", obj[KeyMap.Level])
+}
+
+func TestEnvLOGXIHierarchy(t *testing.T) {
+	assert := assert.New(t)
+	testResetEnv()
+
+	os.Setenv("LOGXI", "*=ERR,server=WRN,server.http=DBG,server.http.router=TRC")
+	processEnv()
+	assert.Equal(LevelTrace, getLogLevel("server.http.router"))
+	assert.Equal(LevelDebug, getLogLevel("server.http.static"))
+	assert.Equal(LevelDebug, getLogLevel("server.http.static.gzip"))
+	assert.Equal(LevelWarn, getLogLevel("server.db"))
+	assert.Equal(LevelError, getLogLevel("serverless"))
+
+	// middle wildcards and character classes
+	os.Setenv("LOGXI", "*=ERR,server.*.router=DBG,db[0-9]=INF,-server.ws")
+	processEnv()
+	assert.Equal(LevelDebug, getLogLevel("server.http.router"))
+	assert.Equal(LevelDebug, getLogLevel("server.http.router.mux"))
+	assert.Equal(LevelInfo, getLogLevel("db1"))
+	assert.Equal(LevelError, getLogLevel("dbx"))
+	assert.Equal(LevelOff, getLogLevel("server.ws.conn"))
+
+	// overlapping patterns resolve deterministically
+	os.Setenv("LOGXI", "dat*=DBG,*base=WRN")
+	for i := 0; i < 20; i++ {
+		processEnv()
+		assert.Equal(LevelWarn, getLogLevel("database"))
+	}
+	os.Setenv("LOGXI", "dat*=DBG,data*=WRN")
+	processEnv()
+	assert.Equal(LevelWarn, getLogLevel("database"), "longer literal wins")
+
+	// the nearest ancestor wins over a more specific pattern of an ancestor
+	os.Setenv("LOGXI", "*=INF,server.http=ERR,*router=DBG")
+	processEnv()
+	assert.Equal(LevelDebug, getLogLevel("server.http.router"))
+	assert.Equal(LevelDebug, getLogLevel("server.http.router.mux"))
+	assert.Equal(LevelError, getLogLevel("server.http.static"))
+}
+
+func TestNamed(t *testing.T) {
+	testResetEnv()
+	os.Setenv("LOGXI", "*=ERR,server=INF,server.db=OFF")
+	processEnv()
+
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "server", NewJSONFormatter("server"))
+	router := l.With("reqID", "abc").Named("http").Named("router")
+	assert.True(t, router.IsInfo())
+	assert.False(t, router.IsDebug())
+	assert.False(t, l.Named("db").IsWarn())
+
+	router.Info("hello")
+	var obj map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "server.http.router", obj[KeyMap.Name])
+	assert.Equal(t, "abc", obj["reqID"])
+
+	buf.Reset()
+	l = NewLogger3(&buf, "server", NewTextFormatter("server"))
+	l.With("reqID", "abc").Named("http").Info("hello")
+	assert.Contains(t, buf.String(), "server.http")
+	assert.Contains(t, buf.String(), "reqID: abc")
+}
+
+func TestRegistry(t *testing.T) {
+	testResetEnv()
+	os.Setenv("LOGXI", "*=ERR,reg.a=OFF")
+	os.Setenv("LOGXI_FORMAT", "text")
+	processEnv()
+
+	var buf bytes.Buffer
+	a := NewLogger3(&buf, "reg.a", NewJSONFormatter("reg.a"))
+	dup := NewLogger(&buf, "reg.b")
+	b := NewLogger(&buf, "reg.b")
+	child := b.With("foo", "bar")
+	b.Error("text")
+	assert.Contains(t, buf.String(), "_m: text")
+
+	l, ok := Lookup("reg.a")
+	assert.True(t, ok)
+	assert.Exactly(t, a, l)
+	_, ok = Lookup("reg.none")
+	assert.False(t, ok)
+	assert.Exactly(t, b, Loggers()["reg.b"])
+
+	// loggers created per request do not grow the registry
+	NewLogger(&buf, "reg.request")
+	size := len(Loggers())
+	for i := 0; i < 1000; i++ {
+		NewLogger(&buf, "reg.request")
+	}
+	assert.Equal(t, size, len(Loggers()))
+
+	// reapplies to existing loggers including disabled ones
+	os.Setenv("LOGXI", "*=ERR,reg.*=DBG")
+	os.Setenv("LOGXI_FORMAT", "JSON")
+	processEnv()
+	assert.True(t, a.IsDebug())
+	assert.True(t, b.IsDebug())
+	assert.False(t, dup.IsDebug(), "a logger with the same name replaces dup")
+
+	// children created before the change follow the formatter of the parent
+	buf.Reset()
+	child.Info("json")
+	var obj map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &obj)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", obj["foo"])
+
+	buf.Reset()
+	b.(*DefaultLogger).SetFormatter(NewTextFormatter("reg.b"))
+	child.Info("text")
+	assert.Contains(t, buf.String(), "foo: bar")
+
+	// explicit formatters are kept
+	buf.Reset()
+	a.Info("json")
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &obj))
+
+	assert.NoError(t, SetLevelByPattern("reg.a", LevelOff))
+	assert.False(t, a.IsWarn())
+	assert.True(t, b.IsDebug())
+	assert.Equal(t, LevelDebug, getLogLevel("reg.a.child"), "reg.* matches the child itself")
+	assert.Error(t, SetLevelByPattern("reg[", LevelOff))
+}
+
+func TestAdminHandler(t *testing.T) {
+	testResetEnv()
+	os.Setenv("LOGXI", "*=ERR,admin=WRN")
+	processEnv()
+
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "admin.models", NewJSONFormatter("admin.models"))
+	ts := httptest.NewServer(AdminHandler())
+	defer ts.Close()
+
+	var find = func(status *AdminStatus, name string) *LoggerInfo {
+		for _, info := range status.Loggers {
+			if info.Name == name {
+				return info
+			}
+		}
+		return nil
+	}
+
+	res, err := http.Get(ts.URL)
+	assert.NoError(t, err)
+	var status AdminStatus
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+	res.Body.Close()
+	info := find(&status, "admin.models")
+	assert.NotNil(t, info)
+	assert.Equal(t, "WRN", info.Level)
+	assert.Equal(t, "admin", info.Pattern)
+	assert.Equal(t, "*log.JSONFormatter", info.Formatter)
+	assert.Nil(t, find(&status, "__logxi"))
+
+	res, err = http.PostForm(ts.URL, url.Values{"LOGXI": {"*=ERR,admin.models=DBG"}})
+	assert.NoError(t, err)
+	status = AdminStatus{}
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&status))
+	res.Body.Close()
+	assert.True(t, l.IsDebug())
+	assert.Equal(t, "DBG", find(&status, "admin.models").Level)
+	assert.Equal(t, "*=ERR,admin.models=DBG", status.Config.Levels)
+
+	req, _ := http.NewRequest("PUT", ts.URL, strings.NewReader(`{"format":"text"}`))
+	req.Header.Set("Content-Type", "application/json")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, FormatText, settings().format)
+	assert.True(t, l.IsDebug(), "levels are unchanged")
+
+	req, _ = http.NewRequest("DELETE", ts.URL, nil)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+
+	// concurrent changes of different values are all kept
+	var wg sync.WaitGroup
+	for _, form := range []url.Values{
+		{"LOGXI_SAMPLE": {"admin=10"}},
+		{"LOGXI_DEDUP": {"admin=1m"}},
+		{"LOGXI_COLORS": {"ERR=red"}},
+	} {
+		wg.Add(1)
+		go func(form url.Values) {
+			defer wg.Done()
+			res, err := http.PostForm(ts.URL, form)
+			if assert.NoError(t, err) {
+				res.Body.Close()
+			}
+		}(form)
+	}
+	wg.Wait()
+	conf := adminStatus().Config
+	assert.Equal(t, "admin=10", conf.Sample)
+	assert.Equal(t, "admin=1m", conf.Dedup)
+	assert.Equal(t, "ERR=red", conf.Colors)
+
+	// invalid values are rejected and the configuration is unchanged
+	for _, form := range []url.Values{
+		{"LOGXI": {"*=ERR,admin.models=LOUD"}},
+		{"LOGXI": {"admin[=DBG"}},
+		{"LOGXI_SAMPLE": {"admin=x"}},
+		{"LOGXI_DEDUP": {"admin=-1s"}},
+	} {
+		res, err := http.PostForm(ts.URL, form)
+		assert.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, form.Encode())
+	}
+	conf = adminStatus().Config
+	assert.Equal(t, "*=ERR,admin.models=DBG", conf.Levels)
+	assert.Equal(t, "admin=10", conf.Sample)
+	assert.Equal(t, "admin=1m", conf.Dedup)
+	assert.True(t, l.IsDebug())
+
+	// request bodies are limited
+	res, err = http.Post(ts.URL, "application/json", strings.NewReader(`{"levels":"`+strings.Repeat("a", maxAdminBody)+`"}`))
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	testResetEnv()
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	testResetEnv()
+	os.Setenv("LOGXI_COLORS", "ERR=red")
+	dir, err := ioutil.TempDir("", "logxi")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	jsonPath := filepath.Join(dir, "logxi.json")
+	ioutil.WriteFile(jsonPath, []byte(`{"levels": "*=ERR,models=DBG", "format": "JSON"}`), 0644)
+	conf, err := LoadConfigFile(jsonPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "*=ERR,models=DBG", conf.Levels)
+	assert.Equal(t, "JSON", conf.Format)
+	assert.Equal(t, "ERR=red", conf.Colors, "defaults to environment")
+
+	iniPath := filepath.Join(dir, "logxi.conf")
+	ioutil.WriteFile(iniPath, []byte(`
+# logxi configuration
+[logxi]
+LOGXI = "*=WRN,models=INF"
+format = 'text'
+; colors
+LOGXI_COLORS = key=cyan
+`), 0644)
+	conf, err = LoadConfigFile(iniPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "*=WRN,models=INF", conf.Levels)
+	assert.Equal(t, "text", conf.Format)
+	assert.Equal(t, "key=cyan", conf.Colors)
+
+	ioutil.WriteFile(iniPath, []byte("bogus = 1\n"), 0644)
+	_, err = LoadConfigFile(iniPath)
+	assert.Error(t, err)
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	testResetEnv()
+	dir, err := ioutil.TempDir("", "logxi")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "logxi.conf")
+	ioutil.WriteFile(path, []byte("LOGXI=*=ERR\n"), 0644)
+
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "watched", NewJSONFormatter("watched"))
+	cw, err := WatchConfigFile(path, 10*time.Millisecond)
+	assert.NoError(t, err)
+	defer cw.Stop()
+	assert.False(t, l.IsDebug())
+
+	ioutil.WriteFile(path, []byte("LOGXI=*=ERR,watched=DBG\n"), 0644)
+	assert.True(t, waitFor(l.IsDebug))
+
+	_, err = WatchConfigFile(filepath.Join(dir, "missing"), time.Second)
+	assert.Error(t, err)
+}
+
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 200 && !cond(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestMemorySource(t *testing.T) {
+	testResetEnv()
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "memsrc", NewJSONFormatter("memsrc"))
+
+	source := NewMemorySource(&Configuration{Levels: "*=ERR,memsrc=INF"})
+	sub, err := Subscribe(source)
+	assert.NoError(t, err)
+	assert.True(t, l.IsInfo())
+	assert.False(t, l.IsDebug())
+
+	source.Set(&Configuration{Levels: "*=ERR,memsrc=DBG"})
+	assert.True(t, waitFor(l.IsDebug))
+
+	sub.Stop()
+	source.Set(&Configuration{Levels: "*=ERR"})
+	assert.True(t, l.IsDebug(), "stopped subscriptions do not process changes")
+}
+
+func TestReconfigureWhileLogging(t *testing.T) {
+	testResetEnv()
+	defer testResetEnv()
+	l := NewLogger(NewConcurrentWriter(ioutil.Discard), "reconf")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			child := l.With("k", "v")
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if child.IsDebug() {
+					child.Debug("debug", "a", 1)
+				}
+				l.Info("info", "b", 2)
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		if i%2 == 0 {
+			ProcessEnv(&Configuration{Levels: "*=DBG", Format: "JSON"})
+		} else {
+			ProcessEnv(&Configuration{Levels: "*=ERR", Format: "text"})
+		}
+		l.(*DefaultLogger).SetFormatter(NewTextFormatter("reconf"))
+	}
+	close(done)
+	wg.Wait()
+	assert.False(t, l.IsDebug())
+}
+
+func TestHTTPSource(t *testing.T) {
+	testResetEnv()
+	var mu sync.Mutex
+	kv := map[string]string{"levels": "*=ERR,httpsrc=INF"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := strings.TrimPrefix(r.URL.Path, "/v2/keys/logxi/")
+		value, ok := kv[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		b, _ := json.Marshal(map[string]interface{}{"node": map[string]string{"value": value}})
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "httpsrc", NewJSONFormatter("httpsrc"))
+	source := NewHTTPSource(ts.URL+"/v2/keys/logxi/{key}", 10*time.Millisecond)
+	source.Decode = DecodeEtcdValue
+
+	conf, err := source.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "*=ERR,httpsrc=INF", conf.Levels)
+	assert.Equal(t, defaultLogxiFormatEnv, conf.Format, "missing keys default to environment")
+
+	sub, err := Subscribe(source)
+	assert.NoError(t, err)
+	defer sub.Stop()
+	assert.True(t, l.IsInfo())
+
+	mu.Lock()
+	kv["levels"] = "*=ERR,httpsrc=DBG"
+	mu.Unlock()
+	assert.True(t, waitFor(l.IsDebug))
+
+	_, err = Subscribe(NewHTTPSource("http://127.0.0.1:0/{key}", time.Second))
+	assert.Error(t, err)
+}
+
+func TestSyslogLevels(t *testing.T) {
+	testResetEnv()
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "syslog", NewTextFormatter("syslog"))
+	l.SetLevel(LevelTrace)
+
+	l.Trace("trace")
+	assert.Contains(t, buf.String(), "_l: TRC")
+	l.Notice("notice")
+	assert.Contains(t, buf.String(), "_l: NTC _m: notice")
+	assert.Error(t, l.Critical("critical"))
+	assert.Contains(t, buf.String(), "_l: CRT _m: critical")
+	assert.Error(t, l.Alert("alert"))
+	assert.Contains(t, buf.String(), "_l: ALR _m: alert")
+	assert.Error(t, l.Emergency("emergency"))
+	assert.Contains(t, buf.String(), "_l: EMR _m: emergency")
+
+	var obj map[string]interface{}
+	buf.Reset()
+	l = NewLogger3(&buf, "syslog", NewJSONFormatter("syslog"))
+	l.SetLevel(LevelError)
+	l.Emergency("emergency")
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &obj))
+	assert.Equal(t, "EMR", obj[KeyMap.Level])
+
+	buf.Reset()
+	l = NewLogger3(&buf, "syslog", NewHappyDevFormatter("syslog"))
+	l.SetLevel(LevelAll)
+	l.Notice("notice")
+	assert.Contains(t, buf.String(), "NTC")
+	l.Alert("alert")
+	assert.Contains(t, buf.String(), "ALR")
+
+	l.SetLevel(LevelWarn)
+	assert.False(t, l.IsNotice())
+	assert.True(t, l.IsError())
+	l.SetLevel(LevelNotice)
+	assert.True(t, l.IsNotice())
+	assert.False(t, l.IsInfo())
+
+	os.Setenv("LOGXI", "*=NTC,crit=critical,emr=EMR,ftl=FTL")
+	processEnv()
+	assert.Equal(t, LevelNotice, getLogLevel("foo"))
+	assert.Equal(t, LevelCritical, getLogLevel("crit"))
+	assert.Equal(t, LevelEmergency, getLogLevel("emr"))
+	assert.Equal(t, LevelFatal, getLogLevel("ftl"))
+	assert.NotEqual(t, LevelCritical, LevelFatal)
+	assert.True(t, LevelFatal < LevelEmergency, "fatal entries are always logged")
+
+	os.Setenv("LOGXI_COLORS", "NTC=cyan,ERR=red")
+	processEnv()
+	theme := settings().theme
+	assert.Equal(t, theme.Error, theme.Alert)
+	assert.NotEqual(t, theme.Info, theme.Notice)
+}
+
+func TestLevelType(t *testing.T) {
+	assert.Equal(t, "DBG", LevelDebug.String())
+	assert.Equal(t, "OFF", LevelOff.String())
+	assert.Equal(t, "Level(42)", Level(42).String())
+
+	for _, s := range []string{"DBG", "debug", "Debug", "dbg", "7"} {
+		level, err := ParseLevel(s)
+		assert.NoError(t, err)
+		assert.Equal(t, LevelDebug, level)
+	}
+	level, err := ParseLevel("oy")
+	assert.Error(t, err)
+	assert.Equal(t, LevelOff, level)
+	var zero Level
+	assert.NotEqual(t, LevelEmergency, zero)
+
+	var conf struct {
+		Level Level `json:"level"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"level":"WRN"}`), &conf))
+	assert.Equal(t, LevelWarn, conf.Level)
+	b, err := json.Marshal(conf)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"level":"WRN"}`, string(b))
+	assert.Error(t, json.Unmarshal([]byte(`{"level":"oy"}`), &conf))
+
+	level = LevelInfo
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&level, "level", "log level")
+	assert.NoError(t, fs.Parse([]string{"-level", "ERR"}))
+	assert.Equal(t, LevelError, level)
+}
+
+func TestRegisterLevel(t *testing.T) {
+	testResetEnv()
+	const LevelAudit Level = -3
+	RegisterLevel("audit", "AUD", LevelAudit, "magenta")
+	defer func() {
+		levelsMutex.Lock()
+		delete(customLevels, LevelAudit)
+		delete(LevelMap, LevelAudit)
+		delete(LevelAtoi, "audit")
+		delete(LevelAtoi, "AUD")
+		levelsMutex.Unlock()
+		testResetEnv()
+	}()
+	assert.Panics(t, func() {
+		RegisterLevel("audit2", "AU2", LevelAudit, "")
+	}, "severity is already registered")
+	assert.Panics(t, func() {
+		RegisterLevel("debug2", "DBG", -3, "")
+	}, "name is already registered")
+
+	assert.Equal(t, "AUD", LevelAudit.String())
+	level, err := ParseLevel("audit")
+	assert.NoError(t, err)
+	assert.Equal(t, LevelAudit, level)
+
+	os.Setenv("LOGXI", "*=ERR,audited=AUD")
+	processEnv()
+	assert.Equal(t, LevelAudit, getLogLevel("audited"))
+
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "audit", NewTextFormatter("audit"))
+	l.SetLevel(LevelError)
+	l.Log(LevelAudit, "audit", []interface{}{"user", "mario"})
+	assert.Contains(t, buf.String(), "_l: AUD _m: audit user: mario")
+
+	buf.Reset()
+	l = NewLogger3(&buf, "audit", NewJSONFormatter("audit"))
+	l.Log(LevelAudit, "audit", nil)
+	var obj map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &obj))
+	assert.Equal(t, "AUD", obj[KeyMap.Level])
+
+	buf.Reset()
+	l = NewLogger3(&buf, "audit", NewHappyDevFormatter("audit"))
+	l.Log(LevelAudit, "audit", nil)
+	assert.Contains(t, buf.String(), "AUD")
+	assert.NotEqual(t, "", settings().theme.Levels[LevelAudit])
+}
+
+type flushBuffer struct {
+	bytes.Buffer
+	flushed int
+}
+
+func (fb *flushBuffer) Flush() error {
+	fb.flushed++
+	return nil
+}
+
+func TestFatalPolicy(t *testing.T) {
+	testResetEnv()
+	var buf flushBuffer
+	l := NewLogger3(&buf, "fatal", NewJSONFormatter("fatal"))
+
+	// default policy panics with *FatalError
+	assert.Panics(t, func() {
+		l.Fatal("could not connect")
+	})
+	assert.Equal(t, 1, buf.flushed)
+
+	var handled []string
+	RegisterExitHandler(func() { handled = append(handled, "first") })
+	RegisterExitHandler(func() { panic("oops") })
+	RegisterExitHandler(func() { handled = append(handled, "last") })
+	defer func() { fatalPolicy.handlers = nil }()
+
+	buf.Reset()
+	err := errors.New("connection refused")
+	fe := CaptureFatal(l, func(l Logger) {
+		l.Fatal("could not connect", "err", err)
+		t.Error("Fatal returned")
+	})
+	assert.NotNil(t, fe)
+	assert.Equal(t, "could not connect", fe.Msg)
+	assert.Equal(t, err, fe.Err)
+	assert.Equal(t, "Exit due to fatal error: could not connect: connection refused", fe.Error())
+	assert.Equal(t, []string{"first", "last"}, handled)
+	assert.Contains(t, buf.String(), `"_m":"could not connect"`)
+
+	assert.Nil(t, CaptureFatal(l, func(l Logger) { l.Error("not fatal") }))
+
+	fe = CaptureFatal(NullLog, func(l Logger) { l.With("k", "v").Fatal("null") })
+	assert.Equal(t, "null", fe.Msg)
+
+	// children are captured and other goroutines keep the fatal policy
+	fe = CaptureFatal(l, func(captured Logger) {
+		done := make(chan interface{})
+		go func() {
+			defer func() { done <- recover() }()
+			l.Fatal("other")
+		}()
+		_, ok := (<-done).(*FatalError)
+		assert.True(t, ok, "PanicOnFatal")
+		captured.With("k", "v").Named("child").Fatal("child")
+	})
+	if assert.NotNil(t, fe) {
+		assert.Equal(t, "child", fe.Msg)
+	}
+	_, ok := Lookup("fatal.child")
+	assert.False(t, ok, "loggers of CaptureFatal are not registered")
+
+	code := -1
+	osExit = func(c int) { code = c }
+	defer func() { osExit = os.Exit }()
+	previous := SetFatalFunc(ExitOnFatal(3))
+	l.Fatal("exit")
+	SetFatalFunc(previous)
+	assert.Equal(t, 3, code)
+}
+
+type recordingFormatter struct {
+	entries []*Entry
+}
+
+func (rf *recordingFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	rf.entries = append(rf.entries, entry)
+	io.WriteString(writer, entry.Level.String()+" "+entry.Message+"\n")
+}
+
+type legacyFormatter struct {
+	args []interface{}
+}
+
+func (lf *legacyFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	lf.args = args
+}
+
+func TestEntryFormatter(t *testing.T) {
+	testResetEnv()
+	rf := &recordingFormatter{}
+	RegisterFormatFactory("recording", func(name, kind string) (Formatter, error) {
+		return NewEntryFormatterAdapter(name, rf), nil
+	})
+	defer delete(formatterCreators, "recording")
+
+	var buf bytes.Buffer
+	formatter, err := createFormatter("entry", "recording")
+	assert.NoError(t, err)
+	l := NewLogger3(&buf, "entry", formatter)
+	l.SetLevel(LevelDebug)
+
+	l.Info("hello", "key", 1, 2, "v", "", "empty")
+	assert.Equal(t, "INF hello\n", buf.String())
+	entry := rf.entries[0]
+	assert.Equal(t, LevelInfo, entry.Level)
+	assert.Equal(t, "entry", entry.Name)
+	assert.False(t, entry.Time.IsZero())
+	assert.Equal(t, []Field{{"key", 1}, {badKeyAtIndex(2), "v"}, {badKeyAtIndex(4), "empty"}}, entry.Fields)
+	assert.Nil(t, entry.Caller)
+	assert.Equal(t, "", entry.Stack)
+
+	errFoo := errors.New("foo")
+	l.With("user", "mario").Warn("oops", "err", errFoo)
+	entry = rf.entries[1]
+	assert.Equal(t, []Field{{"user", "mario"}, {"err", errFoo}}, entry.Fields)
+	value, ok := entry.Value("err")
+	assert.True(t, ok)
+	assert.Equal(t, errFoo, value)
+	assert.NotEqual(t, "", entry.Stack)
+	if assert.NotNil(t, entry.Caller) {
+		assert.Equal(t, "logger_test.go", filepath.Base(entry.Caller.File))
+	}
+
+	l.Debug("single")
+	l.Debug("imbalanced", "a", 1, "b")
+	assert.Equal(t, []Field{{singleArgKey, "single"}}, argsToFields([]interface{}{"single"}))
+	assert.Equal(t, warnImbalancedKey, rf.entries[3].Fields[0].Key)
+
+	// legacy formatters are passed the fields as key-value pairs
+	lf := &legacyFormatter{}
+	l = NewLogger3(&buf, "legacy", lf)
+	l.SetLevel(LevelDebug)
+	l.With("user", "mario").Debug("hello", "key")
+	assert.Equal(t, []interface{}{"user", "mario", singleArgKey, "key"}, lf.args)
+	NewFormatterAdapter(lf).FormatEntry(&buf, NewEntry(LevelInfo, "legacy", "msg", []interface{}{"a", 1}))
+	assert.Equal(t, []interface{}{"a", 1}, lf.args)
+}
+
+func TestHooks(t *testing.T) {
+	testResetEnv()
+	defer ClearHooks()
+
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "hooks", NewJSONFormatter("hooks")).(*DefaultLogger)
+	l.SetLevel(LevelDebug)
+
+	var order []string
+	AddHook(func(entry *Entry) bool {
+		order = append(order, "global")
+		entry.Fields = append(entry.Fields, Field{Key: "host", Value: "example"})
+		return true
+	})
+	l.AddHook(func(entry *Entry) bool {
+		order = append(order, "logger")
+		for i, field := range entry.Fields {
+			if field.Key == "usr" {
+				entry.Fields[i].Key = "user"
+			}
+		}
+		return true
+	})
+	var incidents []string
+	l.AddHook(func(entry *Entry) bool {
+		incidents = append(incidents, entry.Message)
+		return true
+	}, LevelError, LevelCritical)
+	l.AddHook(func(entry *Entry) bool {
+		_, noisy := entry.Value("noisy")
+		return !noisy
+	})
+
+	child := l.With("usr", "mario")
+	child.Info("hello")
+	assert.Equal(t, []string{"global", "logger"}, order)
+	var obj map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &obj))
+	assert.Equal(t, "mario", obj["user"])
+	assert.Equal(t, "example", obj["host"])
+	assert.Nil(t, obj["usr"])
+	assert.Empty(t, incidents)
+
+	buf.Reset()
+	child.Error("failed")
+	assert.Equal(t, []string{"failed"}, incidents)
+	assert.Contains(t, buf.String(), `"user":"mario"`)
+
+	buf.Reset()
+	l.Info("dropped", "noisy", true)
+	assert.Equal(t, "", buf.String())
+
+	// panicking hooks are reported and keep the entry
+	var internal bytes.Buffer
+	internalLog := InternalLog
+	InternalLog = NewLogger3(&internal, "__logxi", NewTextFormatter("__logxi"))
+	InternalLog.SetLevel(LevelError)
+	defer func() { InternalLog = internalLog }()
+	AddHook(func(entry *Entry) bool {
+		panic("broken hook")
+	})
+	l.Warn("still logged")
+	assert.Contains(t, buf.String(), "still logged")
+	assert.Contains(t, internal.String(), "Hook panicked")
+	assert.Contains(t, internal.String(), "broken hook")
+
+	// named loggers inherit hooks and with does not leak to the parent
+	buf.Reset()
+	ClearHooks()
+	named := l.Named("sub")
+	named.(*DefaultLogger).ClearHooks()
+	order = nil
+	l.Info("parent")
+	assert.Equal(t, []string{"logger"}, order)
+}
+
+func TestSampling(t *testing.T) {
+	testResetEnv()
+	os.Setenv("LOGXI", "*=DBG")
+	os.Setenv("LOGXI_SAMPLE", "*=2/3,sampled.quiet:DBG=1,bad=x,worse:OOPS=1")
+	processEnv()
+	defer testResetEnv()
+
+	rules := matchSampleRules("sampled.quiet")
+	if assert.Len(t, rules, 2) {
+		assert.Equal(t, "sampled.quiet", rules[0].pattern)
+		assert.Equal(t, "*", rules[1].pattern)
+	}
+
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "sampled", NewJSONFormatter("sampled"))
+	var lines []map[string]interface{}
+	var decode = func() {
+		lines = nil
+		dec := json.NewDecoder(&buf)
+		for {
+			var obj map[string]interface{}
+			if err := dec.Decode(&obj); err != nil {
+				break
+			}
+			lines = append(lines, obj)
+		}
+		buf.Reset()
+	}
+
+	for i := 0; i < 8; i++ {
+		l.Info("request", "i", i)
+	}
+	decode()
+	// first 2 then every 3rd
+	if assert.Len(t, lines, 4) {
+		assert.Equal(t, float64(0), lines[0]["i"])
+		assert.Equal(t, float64(1), lines[1]["i"])
+		assert.Equal(t, float64(4), lines[2]["i"])
+		assert.Equal(t, float64(2), lines[2][SuppressedKey])
+		assert.Equal(t, float64(7), lines[3]["i"])
+		assert.Nil(t, lines[1][SuppressedKey])
+	}
+
+	// messages are counted separately and errors are never sampled
+	for i := 0; i < 5; i++ {
+		l.Debug("other")
+		l.Error("failed")
+	}
+	decode()
+	assert.Len(t, lines, 3+5)
+
+	// per logger and level rules
+	quiet := l.Named("quiet")
+	for i := 0; i < 5; i++ {
+		quiet.Debug("chatty")
+		quiet.Info("status")
+	}
+	decode()
+	assert.Len(t, lines, 1+3)
+
+	// the interval resets the counts
+	rule := rules[0]
+	now := time.Now()
+	keep, _ := rule.sample("key", now)
+	assert.True(t, keep)
+	keep, _ = rule.sample("key", now)
+	assert.False(t, keep)
+	keep, suppressed := rule.sample("key", now.Add(2*time.Second))
+	assert.True(t, keep)
+	assert.Equal(t, 1, suppressed)
+
+	// counts continue if LOGXI_SAMPLE is unchanged
+	processEnv()
+	assert.Exactly(t, rule, matchSampleRules("sampled.quiet")[0])
+	keep, _ = rule.sample("key", now.Add(2*time.Second))
+	assert.False(t, keep)
+
+	// the number of counters is bounded
+	for i := 0; i < maxSampleCounters+10; i++ {
+		rule.sample(strconv.Itoa(i), now)
+	}
+	assert.True(t, len(rule.counters) <= maxSampleCounters, "counters: %d", len(rule.counters))
+
+	os.Setenv("LOGXI_SAMPLE", "off")
+	processEnv()
+	for i := 0; i < 5; i++ {
+		l.Info("request")
+	}
+	decode()
+	assert.Len(t, lines, 5)
+}
+
+func TestDedup(t *testing.T) {
+	testResetEnv()
+	os.Setenv("LOGXI", "*=DBG")
+	os.Setenv("LOGXI_DEDUP", "*=1h,dedup.db=1h/host,bad=x")
+	processEnv()
+	defer testResetEnv()
+
+	for _, newFormatter := range []func(string) Formatter{
+		func(name string) Formatter { return NewJSONFormatter(name) },
+		func(name string) Formatter { return NewTextFormatter(name) },
+		func(name string) Formatter { return NewHappyDevFormatter(name) },
+	} {
+		var buf bytes.Buffer
+		l := NewLogger3(&buf, "dedup", newFormatter("dedup"))
+		for i := 0; i < 5; i++ {
+			l.Warn("Could not connect, retrying ...", "attempt", i)
+		}
+		l.Info("other")
+		assert.Equal(t, 1, strings.Count(buf.String(), "retrying"), buf.String())
+		assert.Contains(t, buf.String(), "other")
+
+		buf.Reset()
+		assert.NoError(t, Flush())
+		out := buf.String()
+		assert.Contains(t, out, "Could not connect, retrying ... (repeated 4 times in 1h0m0s)")
+		assert.Contains(t, out, RepeatedKey)
+		assert.Contains(t, out, FirstKey)
+		assert.Contains(t, out, LastKey)
+
+		// the window ended
+		buf.Reset()
+		l.Warn("Could not connect, retrying ...")
+		assert.Contains(t, buf.String(), "retrying")
+		Flush()
+	}
+
+	// selected keys are part of the duplicate key
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "dedup.db", NewJSONFormatter("dedup.db"))
+	l.Warn("timeout", "host", "a", "attempt", 1)
+	l.Warn("timeout", "host", "b", "attempt", 1)
+	l.Warn("timeout", "host", "a", "attempt", 2)
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+	buf.Reset()
+	Flush()
+	var obj map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &obj))
+	assert.Equal(t, "a", obj["host"])
+	assert.Equal(t, float64(1), obj[RepeatedKey])
+	assert.Nil(t, obj["attempt"])
+
+	// bound pairs are part of the duplicate key
+	buf.Reset()
+	l.With("host", "a").Warn("bound")
+	l.With("host", "b").Warn("bound")
+	l.With("host", "a").Warn("bound")
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+	buf.Reset()
+	Flush()
+	obj = nil
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &obj))
+	assert.Equal(t, "a", obj["host"])
+	assert.Equal(t, float64(1), obj[RepeatedKey])
+
+	// windows continue if LOGXI_DEDUP is unchanged
+	buf.Reset()
+	l.Warn("reload", "host", "a")
+	processEnv()
+	l.Warn("reload", "host", "a")
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+
+	// pending summaries are written before the rules are replaced
+	os.Setenv("LOGXI_DEDUP", "*=2h")
+	processEnv()
+	assert.Contains(t, buf.String(), "reload (repeated 1 times in 1h0m0s)")
+	Flush()
+
+	// summaries are written at the end of the window
+	rule, err := parseDedupRule("*", "10ms")
+	assert.NoError(t, err)
+	summaries := make(chan *Entry, 1)
+	var emit = func(entry *Entry) { summaries <- entry }
+	assert.True(t, rule.check(NewEntry(LevelWarn, "dedup", "retrying", nil), nil, emit))
+	assert.False(t, rule.check(NewEntry(LevelWarn, "dedup", "retrying", nil), nil, emit))
+	_, _, line, _ := runtime.Caller(0)
+	assert.False(t, rule.check(NewEntry(LevelWarn, "dedup", "retrying", nil), nil, emit))
+	select {
+	case summary := <-summaries:
+		assert.Equal(t, "retrying (repeated 2 times in 10ms)", summary.Message)
+		value, _ := summary.Value(RepeatedKey)
+		assert.Equal(t, 2, value)
+		// the caller of the last duplicate, not of the timer
+		if assert.NotNil(t, summary.Caller) {
+			assert.Equal(t, line+1, summary.Caller.Line)
+			assert.True(t, strings.HasSuffix(summary.Caller.Function, ".TestDedup"), summary.Caller.Function)
+		}
+	case <-time.After(time.Second):
+		t.Error("summary was not written")
+	}
+}
+
+// slowWriter blocks writes until release is closed.
+type slowWriter struct {
+	sync.Mutex
+	bytes.Buffer
+	release chan struct{}
+	flushed bool
+}
+
+func (sw *slowWriter) Write(p []byte) (int, error) {
+	<-sw.release
+	sw.Lock()
+	defer sw.Unlock()
+	return sw.Buffer.Write(p)
+}
+
+func (sw *slowWriter) Flush() error {
+	sw.Lock()
+	defer sw.Unlock()
+	sw.flushed = true
+	return nil
+}
+
+func (sw *slowWriter) String() string {
+	sw.Lock()
+	defer sw.Unlock()
+	return sw.Buffer.String()
+}
+
+func TestAsyncWriter(t *testing.T) {
+	testResetEnv()
+	os.Setenv("LOGXI_FORMAT", "text")
+	processEnv()
+	defer testResetEnv()
+
+	// entries are written in order
+	sw := &slowWriter{release: make(chan struct{})}
+	close(sw.release)
+	aw := NewAsyncWriter(sw, 10, OverflowBlock)
+	l := NewLogger3(NewConcurrentWriter(aw), "async", NewTextFormatter("async"))
+	l.SetLevel(LevelDebug)
+	for i := 0; i < 20; i++ {
+		l.Info("entry", "i", i)
+	}
+	assert.NoError(t, aw.Flush())
+	assert.True(t, sw.flushed)
+	assert.Equal(t, 20, strings.Count(sw.String(), "_m: entry"))
+	assert.True(t, strings.Index(sw.String(), "i: 3\n") < strings.Index(sw.String(), "i: 4\n"))
+	assert.NoError(t, aw.Close())
+	_, err := aw.Write([]byte("closed"))
+	assert.Equal(t, ErrWriterClosed, err)
+
+	var fill = func(policy OverflowPolicy) (*slowWriter, *AsyncWriter, Logger) {
+		sw := &slowWriter{release: make(chan struct{})}
+		aw := NewAsyncWriter(sw, 2, policy)
+		aw.SetFormatter(NewTextFormatter("async"))
+		l := NewLogger3(aw, "async", NewTextFormatter("async"))
+		l.SetLevel(LevelDebug)
+		// the first entry is taken by the goroutine which blocks
+		l.Info("first")
+		for aw.queueLen() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		l.Info("a")
+		l.Info("b")
+		return sw, aw, l
+	}
+
+	sw, aw, l = fill(OverflowDropNewest)
+	l.Info("c")
+	l.Info("d")
+	close(sw.release)
+	aw.Flush()
+	out := sw.String()
+	assert.Contains(t, out, "_m: b")
+	assert.NotContains(t, out, "_m: c")
+	assert.Contains(t, out, "Dropped log entries")
+	assert.Contains(t, out, DroppedKey+": 2")
+	aw.Close()
+
+	sw, aw, l = fill(OverflowDropOldest)
+	l.Info("c")
+	close(sw.release)
+	aw.Flush()
+	out = sw.String()
+	assert.NotContains(t, out, "_m: a")
+	assert.Contains(t, out, "_m: c")
+	assert.Contains(t, out, DroppedKey+": 1")
+	aw.Close()
+
+	sw, aw, l = fill(OverflowBlockErrors)
+	l.Info("c")
+	done := make(chan bool)
+	go func() {
+		l.Error("important")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Error("error was not blocked")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(sw.release)
+	<-done
+	assert.NoError(t, Shutdown())
+	out = sw.String()
+	assert.NotContains(t, out, "_m: c")
+	assert.Contains(t, out, "_m: important")
+	assert.Contains(t, out, DroppedKey+": 1")
+
+	// without a formatter dropped entries are reported with InternalLog
+	testBuf.Reset()
+	sw, aw, l = fill(OverflowDropNewest)
+	aw.SetFormatter(nil)
+	l.Info("c")
+	close(sw.release)
+	aw.Flush()
+	assert.NotContains(t, sw.String(), DroppedKey)
+	assert.Contains(t, testBuf.String(), DroppedKey+": 1")
+	aw.Close()
+
+	// dropped entries are reported while the queue does not drain
+	dw := &delayWriter{delay: time.Millisecond}
+	aw = NewAsyncWriter(dw, 1, OverflowDropNewest)
+	aw.reportInterval = 10 * time.Millisecond
+	aw.SetFormatter(NewTextFormatter("async"))
+	l = NewLogger3(aw, "async", NewTextFormatter("async"))
+	l.SetLevel(LevelDebug)
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(dw.String(), "Dropped log entries") && time.Now().Before(deadline) {
+		l.Info("flood")
+	}
+	assert.Contains(t, dw.String(), "Dropped log entries")
+	aw.Close()
+}
+
+// delayWriter sleeps before each write.
+type delayWriter struct {
+	sync.Mutex
+	buf   bytes.Buffer
+	delay time.Duration
+}
+
+func (dw *delayWriter) Write(p []byte) (int, error) {
+	time.Sleep(dw.delay)
+	dw.Lock()
+	defer dw.Unlock()
+	return dw.buf.Write(p)
+}
+
+func (dw *delayWriter) String() string {
+	dw.Lock()
+	defer dw.Unlock()
+	return dw.buf.String()
+}
+
+func TestSinks(t *testing.T) {
+	testResetEnv()
+	os.Setenv("LOGXI", "*=DBG")
+	processEnv()
+	defer testResetEnv()
+
+	var term, file, remote bytes.Buffer
+	l := NewSinkLogger("sinks",
+		NewSink(&term, NewHappyDevFormatter("sinks"), LevelDebug),
+		NewSink(&file, NewJSONFormatter("sinks"), LevelInfo),
+		&Sink{Writer: &remote, Formatter: NewTextFormatter("sinks"), Level: LevelError, Filter: func(entry *Entry) bool {
+			_, ok := entry.Value("page")
+			return !ok
+		}},
+	)
+	l.Debug("dbg")
+	l.Info("info", "user", "gopher")
+	l.Error("oops", "err", errors.New("boom"))
+	l.Error("paged", "page", true)
+	assert.Contains(t, term.String(), "dbg")
+	assert.Contains(t, term.String(), "info")
+	assert.Contains(t, term.String(), "paged")
+	assert.NotContains(t, file.String(), "dbg")
+	assert.Contains(t, file.String(), `"user":"gopher"`)
+	assert.Contains(t, file.String(), `"_m":"oops"`)
+	assert.NotContains(t, remote.String(), "info")
+	assert.Contains(t, remote.String(), "oops")
+	assert.NotContains(t, remote.String(), "paged")
+
+	// the logger level filters first
+	term.Reset()
+	l.SetLevel(LevelInfo)
+	l.Debug("dbg")
+	assert.Empty(t, term.String())
+	l.SetLevel(LevelDebug)
+
+	// sinks write pairs bound with With and are renamed by Named
+	file.Reset()
+	l.With("request", 7).Info("with")
+	l.Named("http").Info("named")
+	lines := strings.Split(strings.TrimSpace(file.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	var obj map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &obj))
+	assert.Equal(t, float64(7), obj["request"])
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &obj))
+	assert.Equal(t, "sinks.http", obj["_n"])
+
+	// sinks are in addition to the writer of the logger
+	var main, extra bytes.Buffer
+	l2 := NewLogger3(&main, "sinks2", NewTextFormatter("sinks2")).(*DefaultLogger)
+	l2.AddSink(NewSink(&extra, NewJSONFormatter("sinks2"), LevelAll))
+	l2.Info("both")
+	assert.Contains(t, main.String(), "both")
+	assert.Contains(t, extra.String(), `"_m":"both"`)
+
+	// writers of sinks are flushed
+	fb := &flushBuffer{}
+	l2.AddSink(NewSink(fb, nil, LevelAll))
+	assert.NoError(t, Flush())
+	assert.Equal(t, 1, fb.flushed)
+
+	assert.Panics(t, func() {
+		l2.AddSink(&Sink{})
+	})
+}
+
+func TestEnvSinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logxi")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	all := filepath.Join(dir, "all.log")
+	errs := filepath.Join(dir, "errors.log")
+
+	var internal bytes.Buffer
+	testResetEnv()
+	InternalLog = NewLogger3(&internal, "__logxi", NewTextFormatter("__logxi"))
+	InternalLog.SetLevel(LevelError)
+	os.Setenv("LOGXI", "*=DBG")
+	os.Setenv("LOGXI_FORMAT", "text")
+	os.Setenv("LOGXI_SINKS", all+"=JSON/INF,file://"+errs+"=/ERR/envsinks.db,bogus://x=JSON,"+all+"=yaml")
+	processEnv()
+	defer testResetEnv()
+	assert.Contains(t, internal.String(), "unknown scheme")
+	assert.Contains(t, internal.String(), "unknown format")
+
+	var buf bytes.Buffer
+	l := NewLogger(&buf, "envsinks")
+	db := l.(*DefaultLogger).Named("db")
+	l.Debug("dbg")
+	l.Error("oops")
+	db.Error("db down")
+	// NewLogger3 loggers do not use LOGXI_SINKS
+	NewLogger3(&buf, "envsinks.db", NewTextFormatter("envsinks.db")).Error("not sunk")
+	assert.Contains(t, buf.String(), "dbg")
+
+	b, err := ioutil.ReadFile(all)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "dbg")
+	assert.Contains(t, string(b), `"_m":"oops"`)
+	assert.Contains(t, string(b), `"_m":"db down"`)
+	assert.NotContains(t, string(b), "not sunk")
+
+	b, err = ioutil.ReadFile(errs)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "oops")
+	assert.Contains(t, string(b), "db down")
+	// the format defaults to LOGXI_FORMAT
+	assert.False(t, strings.HasPrefix(string(b), "{"), string(b))
+
+	// reprocessing removes sinks
+	os.Setenv("LOGXI_SINKS", "off")
+	processEnv()
+	l.Error("after")
+	b, err = ioutil.ReadFile(all)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "after")
+	assert.Empty(t, sinkWriters)
+
+	// targets may contain "="
+	query := filepath.Join(dir, "a=b.log")
+	os.Setenv("LOGXI_SINKS", "file://"+query+"=JSON,"+query+".2=")
+	processEnv()
+	l.Error("query")
+	for _, name := range []string{query, query + ".2"} {
+		b, err = ioutil.ReadFile(name)
+		assert.NoError(t, err)
+		assert.Contains(t, string(b), "query")
+	}
+	target, value := splitEnvSink("tcp://logs:5000/?a=b=JSON/ERR")
+	assert.Equal(t, "tcp://logs:5000/?a=b", target)
+	assert.Equal(t, "JSON/ERR", value)
+
+	// removed writers are closed after entries written with them
+	RegisterSinkFactory("closecheck", func(target string) (io.Writer, error) {
+		return &closeCheckWriter{t: t}, nil
+	})
+	concurrent := NewLogger(NewConcurrentWriter(ioutil.Discard), "envsinks.concurrent")
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					concurrent.Error("concurrent")
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		os.Setenv("LOGXI_SINKS", "closecheck://"+strconv.Itoa(i))
+		processEnv()
+	}
+	close(done)
+	wg.Wait()
+}
+
+// closeCheckWriter fails the test if it is written after it is closed.
+type closeCheckWriter struct {
+	sync.Mutex
+	t      *testing.T
+	closed bool
+}
+
+func (cw *closeCheckWriter) Write(p []byte) (int, error) {
+	cw.Lock()
+	defer cw.Unlock()
+	if cw.closed {
+		cw.t.Error("write after close")
+	}
+	return len(p), nil
+}
+
+func (cw *closeCheckWriter) Close() error {
+	cw.Lock()
+	defer cw.Unlock()
+	cw.closed = true
+	return nil
+}
+
+func TestRotatingWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logxi")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "logs", "app.log")
+
+	// rotate by size, keep the 2 most recent rotated files compressed
+	rw, err := NewRotatingWriter(filename, RotateOptions{MaxSize: 20, MaxCount: 2, Compress: true})
+	assert.NoError(t, err)
+	now := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	rw.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	l := NewLogger3(rw, "rotate", NewTextFormatter("rotate"))
+	for i := 0; i < 4; i++ {
+		rw.Write([]byte("0123456789abcdef\n"))
+	}
+	l.Error("after")
+	assert.NoError(t, rw.Close())
+	_, err = rw.Write([]byte("closed"))
+	assert.Equal(t, ErrWriterClosed, err)
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "logs", "app-*"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(rotated), "%v", rotated)
+	for _, name := range rotated {
+		assert.True(t, strings.HasSuffix(name, ".log.gz"), name)
+		f, err := os.Open(name)
+		assert.NoError(t, err)
+		zr, err := gzip.NewReader(f)
+		assert.NoError(t, err)
+		b, err := ioutil.ReadAll(zr)
+		assert.NoError(t, err)
+		assert.Equal(t, "0123456789abcdef\n", string(b))
+		f.Close()
+	}
+	b, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "after")
+
+	// rotate by time and remove old files
+	filename = filepath.Join(dir, "time.log")
+	old := filepath.Join(dir, "time-2015-01-01T00-00-00.000.log")
+	assert.NoError(t, ioutil.WriteFile(old, []byte("old"), 0644))
+	assert.NoError(t, os.Chtimes(old, now.Add(-48*time.Hour), now.Add(-48*time.Hour)))
+	rw, err = NewRotatingWriter(filename, RotateOptions{Interval: time.Hour, MaxAge: 24 * time.Hour})
+	assert.NoError(t, err)
+	rw.now = func() time.Time { return now }
+	rw.Reopen()
+	rw.Write([]byte("first\n"))
+	now = now.Add(time.Hour)
+	rw.Write([]byte("second\n"))
+	assert.NoError(t, rw.Close())
+	assert.False(t, fileExists(old))
+	b, err = ioutil.ReadFile(filepath.Join(dir, "time-"+now.Format(rotateTimeFormat)+".log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "first\n", string(b))
+	b, err = ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "second\n", string(b))
+
+	// intervals count from local midnight
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	at := time.Date(2016, 1, 2, 23, 30, 0, 0, zone)
+	assert.Equal(t, time.Date(2016, 1, 3, 0, 0, 0, 0, zone), nextRotation(at, 24*time.Hour))
+	assert.Equal(t, time.Date(2016, 1, 4, 0, 0, 0, 0, zone), nextRotation(at, 48*time.Hour))
+	assert.Equal(t, time.Date(2016, 1, 3, 0, 0, 0, 0, zone), nextRotation(at, time.Hour))
+	assert.Equal(t, time.Date(2016, 1, 2, 23, 45, 0, 0, zone), nextRotation(at, 15*time.Minute))
+
+	// reopen on SIGHUP after logrotate moved the file
+	filename = filepath.Join(dir, "hup.log")
+	rw, err = NewRotatingWriter(filename, RotateOptions{})
+	assert.NoError(t, err)
+	defer rw.Close()
+	rw.Write([]byte("before\n"))
+	assert.NoError(t, os.Rename(filename, filename+".1"))
+	proc, err := os.FindProcess(os.Getpid())
+	assert.NoError(t, err)
+	assert.NoError(t, proc.Signal(syscall.SIGHUP))
+	assert.True(t, waitFor(func() bool { return fileExists(filename) }))
+	rw.Write([]byte("after\n"))
+	b, err = ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "after\n", string(b))
+}
+
+func TestSyslogFormatter(t *testing.T) {
+	testResetEnv()
+	var buf bytes.Buffer
+	sf := NewSyslogFormatter("app")
+	sf.Hostname = "host"
+	sf.Facility = FacilityLocal0
+	entry := &Entry{
+		Time:    time.Date(2016, 1, 2, 3, 4, 5, 6000, time.UTC),
+		Level:   LevelError,
+		Message: "oops",
+		Fields:  []Field{{Key: "path", Value: `C:\tmp]"`}, {Key: "bad key=", Value: 1}},
+	}
+	sf.FormatEntry(&buf, entry)
+	assert.Equal(t, `<131>1 2016-01-02T03:04:05.000006Z host app `+pidStr+` - [logxi@32473 path="C:\\tmp\]\"" bad_key_="1"] oops`+"\n", buf.String())
+
+	buf.Reset()
+	entry.Level = LevelDebug
+	entry.Fields = nil
+	sf.FormatEntry(&buf, entry)
+	assert.Equal(t, `<135>1 2016-01-02T03:04:05.000006Z host app `+pidStr+` - - oops`+"\n", buf.String())
+
+	buf.Reset()
+	sf.RFC3164 = true
+	entry.Level = LevelWarn
+	entry.Fields = []Field{{Key: "user", Value: "go pher"}, {Key: "n", Value: 2}}
+	sf.FormatEntry(&buf, entry)
+	assert.Equal(t, `<132>Jan  2 03:04:05 host app[`+pidStr+`]: oops user="go pher" n=2`+"\n", buf.String())
+
+	// named copies keep the options
+	buf.Reset()
+	withName(sf, "app.db").(EntryFormatter).FormatEntry(&buf, entry)
+	assert.Contains(t, buf.String(), " app.db[")
+
+	assert.Equal(t, 5, syslogSeverity(-3))
+	assert.Equal(t, 2, syslogSeverity(LevelCritical))
+	assert.Equal(t, 0, syslogSeverity(LevelEmergency))
+	assert.Equal(t, 7, syslogSeverity(LevelTrace))
+	assert.Equal(t, 2, syslogSeverity(LevelFatal))
+
+	os.Setenv("LOGXI_FORMAT", "syslog,facility=daemon")
+	processEnv()
+	defer testResetEnv()
+	formatter, err := createFormatter("app", FormatEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, FacilityDaemon, formatter.(*SyslogFormatter).Facility)
+	_, err = ParseFacility("bogus")
+	assert.Error(t, err)
+}
+
+func TestSyslogWriter(t *testing.T) {
+	testResetEnv()
+	formatter := NewSyslogFormatter("app")
+
+	// UDP
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+	sw, err := NewSyslogWriter("udp", pc.LocalAddr().String())
+	assert.NoError(t, err)
+	l := NewLogger3(sw, "app", formatter)
+	l.Error("over udp", "n", 1)
+	b := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(b)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^<11>1 \S+ \S+ app \d+ - \[logxi@32473 n="1"\] over udp$`, string(b[:n]))
+	sw.Close()
+	_, err = sw.Write([]byte("closed"))
+	assert.Equal(t, ErrWriterClosed, err)
+
+	// unix datagram
+	dir, err := ioutil.TempDir("", "logxi")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "log.sock")
+	upc, err := net.ListenPacket("unixgram", sock)
+	assert.NoError(t, err)
+	defer upc.Close()
+	sw, err = NewSyslogWriter("unixgram", sock)
+	assert.NoError(t, err)
+	defer sw.Close()
+	NewLogger3(sw, "app", formatter).Error("over unixgram")
+	upc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err = upc.ReadFrom(b)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(b[:n]), " - - over unixgram"), string(b[:n]))
+
+	// TCP with octet counting and reconnect
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	sw, err = NewSyslogWriter("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	defer sw.Close()
+	l = NewLogger3(sw, "app", formatter)
+	l.Error("first")
+	l.Error("second")
+	conn := <-conns
+	msgs := readOctetCounted(t, conn, 2)
+	assert.True(t, strings.HasSuffix(msgs[0], " first"), msgs[0])
+	assert.True(t, strings.HasSuffix(msgs[1], " second"), msgs[1])
+
+	// the server closes the connection
+	conn.Close()
+	var reconnected net.Conn
+	assert.True(t, waitFor(func() bool {
+		if reconnected != nil {
+			return true
+		}
+		l.Error("again")
+		select {
+		case reconnected = <-conns:
+			return true
+		default:
+			return false
+		}
+	}))
+	defer reconnected.Close()
+	msgs = readOctetCounted(t, reconnected, 1)
+	assert.True(t, strings.HasSuffix(msgs[0], " again"), msgs[0])
+}
+
+// readOctetCounted reads n messages framed with octet counting.
+func readOctetCounted(t *testing.T, conn net.Conn, n int) []string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	var msgs []string
+	for i := 0; i < n; i++ {
+		length, err := r.ReadString(' ')
+		if !assert.NoError(t, err) {
+			return msgs
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(length))
+		assert.NoError(t, err)
+		msg := make([]byte, size)
+		_, err = io.ReadFull(r, msg)
+		assert.NoError(t, err)
+		msgs = append(msgs, string(msg))
+	}
+	return msgs
+}
+
+// parseJournalFields decodes the journald native protocol.
+func parseJournalFields(t *testing.T, b []byte) map[string]string {
+	fields := map[string]string{}
+	for len(b) > 0 {
+		idx := bytes.IndexAny(b, "=\n")
+		if !assert.True(t, idx > 0, string(b)) {
+			return fields
+		}
+		key := string(b[:idx])
+		if b[idx] == '=' {
+			end := bytes.IndexByte(b, '\n')
+			fields[key] = string(b[idx+1 : end])
+			b = b[end+1:]
+			continue
+		}
+		size := int(binary.LittleEndian.Uint64(b[idx+1:]))
+		start := idx + 9
+		fields[key] = string(b[start : start+size])
+		assert.Equal(t, byte('\n'), b[start+size])
+		b = b[start+size+1:]
+	}
+	return fields
+}
+
+func TestJournalFormatter(t *testing.T) {
+	testResetEnv()
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "app", NewJournalFormatter("app"))
+	// off a TTY the default level is ERR
+	l.SetLevel(LevelWarn)
+	l.Warn("disk\nfull", "mount point", "/var", "_suppressed", 3, "9lives", true, "text", "a\nb")
+	fields := parseJournalFields(t, buf.Bytes())
+	assert.Equal(t, "disk\nfull", fields["MESSAGE"])
+	assert.Equal(t, "4", fields["PRIORITY"])
+	assert.Equal(t, "app", fields["SYSLOG_IDENTIFIER"])
+	assert.True(t, strings.HasSuffix(fields["CODE_FILE"], "logger_test.go"), fields["CODE_FILE"])
+	assert.NotEmpty(t, fields["CODE_LINE"])
+	assert.Contains(t, fields["CODE_FUNC"], "TestJournalFormatter")
+	assert.Equal(t, "/var", fields["MOUNT_POINT"])
+	assert.Equal(t, "3", fields["SUPPRESSED"])
+	assert.Equal(t, "true", fields["FIELD_9LIVES"])
+	assert.Equal(t, "a\nb", fields["TEXT"])
+
+	// every level has a code location and keys do not replace reserved fields
+	buf.Reset()
+	l.SetLevel(LevelInfo)
+	l.Info("hello", "message", "spoof", "priority", 0)
+	fields = parseJournalFields(t, buf.Bytes())
+	assert.Equal(t, "hello", fields["MESSAGE"])
+	assert.Equal(t, "6", fields["PRIORITY"])
+	assert.Equal(t, "spoof", fields["FIELD_MESSAGE"])
+	assert.Equal(t, "0", fields["FIELD_PRIORITY"])
+	assert.True(t, strings.HasSuffix(fields["CODE_FILE"], "logger_test.go"), fields["CODE_FILE"])
+
+	// sinks with a JournalFormatter get the caller
+	buf.Reset()
+	sl := NewSinkLogger("app", NewSink(&buf, NewJournalFormatter("app"), LevelInfo))
+	sl.SetLevel(LevelInfo)
+	sl.Info("hello")
+	fields = parseJournalFields(t, buf.Bytes())
+	assert.Contains(t, fields["CODE_FUNC"], "TestJournalFormatter")
+}
+
+func TestGELFFormatter(t *testing.T) {
+	testResetEnv()
+	var buf bytes.Buffer
+	gf := NewGELFFormatter("app")
+	gf.Host = "web1"
+	entry := &Entry{
+		Time:    time.Unix(1451703845, 7000000),
+		Level:   LevelError,
+		Message: "oops",
+		Fields:  []Field{{Key: "err", Value: errors.New("boom")}, {Key: "id", Value: 7}, {Key: "user name", Value: "gopher"}, {Key: "ok", Value: true}},
+		Stack:   "goroutine 1 [running]:",
+	}
+	gf.FormatEntry(&buf, entry)
+	var obj map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &obj))
+	assert.Equal(t, "1.1", obj["version"])
+	assert.Equal(t, "web1", obj["host"])
+	assert.Equal(t, "oops", obj["short_message"])
+	assert.Equal(t, "goroutine 1 [running]:", obj["full_message"])
+	assert.Equal(t, 1451703845.007, obj["timestamp"])
+	assert.Equal(t, float64(3), obj["level"])
+	assert.Equal(t, "app", obj[KeyMap.Name])
+	assert.Equal(t, float64(pid), obj[KeyMap.PID])
+	assert.Equal(t, "boom", obj["_err"])
+	assert.Equal(t, float64(7), obj["_id_"])
+	assert.Equal(t, "gopher", obj["_user_name"])
+	assert.Equal(t, "true", obj["_ok"])
+	assert.Contains(t, buf.String(), `"timestamp":1451703845.007,`)
+}
+
+func TestGELFWriter(t *testing.T) {
+	testResetEnv()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+	read := func() []byte {
+		b := make([]byte, 65536)
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(b)
+		assert.NoError(t, err)
+		return b[:n]
+	}
+
+	for _, compression := range []GELFCompression{GELFCompressGzip, GELFCompressZlib, GELFCompressNone} {
+		gw, err := NewGELFWriter("udp", pc.LocalAddr().String(), compression)
+		assert.NoError(t, err)
+		l := NewLogger3(gw, "app", NewGELFFormatter("app"))
+		l.Error("small")
+		b := read()
+		var r io.Reader = bytes.NewReader(b)
+		switch compression {
+		case GELFCompressGzip:
+			r, err = gzip.NewReader(r)
+		case GELFCompressZlib:
+			r, err = zlib.NewReader(r)
+		}
+		assert.NoError(t, err)
+		var obj map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r).Decode(&obj))
+		assert.Equal(t, "small", obj["short_message"])
+		gw.Close()
+	}
+
+	// large messages are chunked
+	gw, err := NewGELFWriter("udp", pc.LocalAddr().String(), GELFCompressNone)
+	assert.NoError(t, err)
+	defer gw.Close()
+	gw.ChunkSize = 100
+	l := NewLogger3(gw, "app", NewGELFFormatter("app"))
+	large := strings.Repeat("x", 1000)
+	l.Error("large", "data", large)
+	var msg []byte
+	var id []byte
+	for i := 0; ; i++ {
+		chunk := read()
+		assert.True(t, len(chunk) <= 100)
+		assert.Equal(t, []byte{0x1e, 0x0f}, chunk[:2])
+		if id == nil {
+			id = chunk[2:10]
+		}
+		assert.Equal(t, id, chunk[2:10])
+		assert.Equal(t, byte(i), chunk[10])
+		msg = append(msg, chunk[12:]...)
+		if int(chunk[11]) == i+1 {
+			break
+		}
+	}
+	var obj map[string]interface{}
+	assert.NoError(t, json.Unmarshal(msg, &obj))
+	assert.Equal(t, large, obj["_data"])
+
+	gw.ChunkSize = 13
+	_, err = gw.Write([]byte(strings.Repeat("x", 200)))
+	assert.Error(t, err)
+	for _, size := range []int{12, 0, -1} {
+		gw.ChunkSize = size
+		assert.NotPanics(t, func() {
+			_, err = gw.Write([]byte(strings.Repeat("x", 200)))
+		})
+		assert.Error(t, err)
+	}
+
+	// TCP messages are delimited by a null byte
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	gw, err = NewGELFWriter("tcp", ln.Addr().String(), GELFCompressGzip)
+	assert.NoError(t, err)
+	defer gw.Close()
+	conn, err := ln.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+	l = NewLogger3(gw, "app", NewGELFFormatter("app"))
+	l.Error("first")
+	l.Error("second")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	for _, expected := range []string{"first", "second"} {
+		b, err := br.ReadBytes(0)
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(b[:len(b)-1], &obj))
+		assert.Equal(t, expected, obj["short_message"])
+	}
+}
+
+func TestLogfmtFormatter(t *testing.T) {
+	testResetEnv()
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "app", NewLogfmtFormatter("app")).With("request id", `a"b`)
+	l.Error("Could not save: disk full", "user", "go pher", "eq", "a=b", "empty", "", "n", 42, "err", errors.New("boom\nline 2"), "utf8", "héllo")
+	line := buf.String()
+	assert.Equal(t, 1, strings.Count(line, "\n"), line)
+	assert.True(t, strings.HasSuffix(line, "\n"))
+	assert.Contains(t, line, ` _l=ERR _m="Could not save: disk full" "request id"="a\"b" user="go pher" eq="a=b" empty="" n=42 err="boom\nline 2" _c="`)
+	assert.Contains(t, line, "utf8=héllo")
+
+	fields, err := ParseLogfmt(line)
+	assert.NoError(t, err)
+	values := map[string]interface{}{}
+	var keys []string
+	for _, field := range fields {
+		keys = append(keys, field.Key)
+		values[field.Key] = field.Value
+	}
+	assert.Equal(t, []string{KeyMap.Time, KeyMap.PID, KeyMap.Name, KeyMap.Level, KeyMap.Message, "request id", "user", "eq", "empty", "n", "err", KeyMap.CallStack, "utf8"}, keys)
+	assert.Equal(t, "Could not save: disk full", values[KeyMap.Message])
+	assert.Equal(t, `a"b`, values["request id"])
+	assert.Equal(t, "go pher", values["user"])
+	assert.Equal(t, "a=b", values["eq"])
+	assert.Equal(t, "", values["empty"])
+	assert.Equal(t, "42", values["n"])
+	assert.Equal(t, "boom\nline 2", values["err"])
+	assert.Contains(t, values[KeyMap.CallStack], "goroutine")
+	assert.Equal(t, "héllo", values["utf8"])
+
+	fields, err = ParseLogfmt(`a=1 flag b= c="x y"`)
+	assert.NoError(t, err)
+	assert.Equal(t, []Field{{Key: "a", Value: "1"}, {Key: "flag"}, {Key: "b", Value: ""}, {Key: "c", Value: "x y"}}, fields)
+	for _, bad := range []string{`a="x`, `=1`, `a=x"y`, `a="\q"`} {
+		_, err = ParseLogfmt(bad)
+		assert.Error(t, err, bad)
+	}
+
+	formatter, err := createFormatter("app", FormatLogfmt)
+	assert.NoError(t, err)
+	_, ok := formatter.(*LogfmtFormatter)
+	assert.True(t, ok)
+}
+
+func TestLTSVFormatter(t *testing.T) {
+	testResetEnv()
+	os.Setenv("LOGXI_FORMAT", "LTSV")
+	processEnv()
+	assert.Equal(t, FormatLTSV, settings().format)
+	assert.Equal(t, ": ", AssignmentChar, "LTSV does not change the text separators")
+	assert.Equal(t, " ", Separator)
+
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "app", NewLTSVFormatter("app")).With("request id", "a\tb")
+	l.Error("Could not save\ndisk full", "path", `C:\tmp`, "err", errors.New("boom"))
+	line := buf.String()
+	assert.Equal(t, 1, strings.Count(line, "\n"), line)
+	assert.True(t, strings.HasPrefix(line, KeyMap.Time+":"))
+	assert.Contains(t, line, "\t_l:ERR\t_m:Could not save\\ndisk full\trequest_id:a\\tb\tpath:C:\\\\tmp\terr:boom\t_c:goroutine")
+
+	labels := map[string]string{}
+	for _, pair := range strings.Split(strings.TrimSuffix(line, "\n"), "\t") {
+		idx := strings.Index(pair, ":")
+		assert.True(t, idx > 0, pair)
+		labels[pair[:idx]] = pair[idx+1:]
+	}
+	assert.Equal(t, "app", labels[KeyMap.Name])
+	assert.Equal(t, pidStr, labels[KeyMap.PID])
+
+	buf.Reset()
+	NewLogger3(&buf, "app", NewTextFormatter("app")).Error("hello", "k", "v")
+	assert.Contains(t, buf.String(), "_m: hello k: v", "text formatter coexists with LTSV")
+
+	formatter, err := createFormatter("app", FormatEnv)
+	assert.NoError(t, err)
+	_, ok := formatter.(*LTSVFormatter)
+	assert.True(t, ok)
+}
+
+func TestColumnFormatter(t *testing.T) {
+	testResetEnv()
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "app", NewCSVFormatter("app", KeyMap.Level, KeyMap.Message, "user", "missing")).With("user", "go, pher")
+	l.Error(`say "hi"`)
+	l.Error("two\nlines", "user", "override")
+	r := csv.NewReader(&buf)
+	records, err := r.ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"ERR", `say "hi"`, "go, pher", ""},
+		{"ERR", "two\nlines", "override", ""},
+	}, records)
+
+	buf.Reset()
+	l = NewLogger3(&buf, "app", NewTSVFormatter("app", KeyMap.Name, KeyMap.Message, "n"))
+	l.Error("a\tb\nc", "n", 1)
+	assert.Equal(t, "app\ta\\tb\\nc\t1\n", buf.String())
+
+	os.Setenv("LOGXI_FORMAT", "tsv,columns=_l/user")
+	processEnv()
+	buf.Reset()
+	NewLogger(&buf, "app").Error("hello", "user", "gopher")
+	assert.Equal(t, "ERR\tgopher\n", buf.String())
+
+	os.Setenv("LOGXI_FORMAT", "csv")
+	processEnv()
+	formatter := NewCSVFormatter("app")
+	assert.Equal(t, []string{KeyMap.Time, KeyMap.Level, KeyMap.Name, KeyMap.Message}, formatter.Columns)
+}
+
+type testPoint struct {
+	X int     `json:"x"`
+	Y float64 `json:"y"`
+}
+
+func TestBinaryFormatter(t *testing.T) {
+	testResetEnv()
+	tm := time.Date(2016, 1, 2, 3, 4, 5, 123456000, time.UTC)
+	long := strings.Repeat("x", 300)
+	decoders := map[string]func(io.Reader) *BinaryDecoder{
+		FormatCBOR:    NewCBORDecoder,
+		FormatMsgpack: NewMsgpackDecoder,
+	}
+	for kind, newDecoder := range decoders {
+		var buf bytes.Buffer
+		formatter, err := createFormatter("app", kind)
+		assert.NoError(t, err)
+		l := NewLogger3(&buf, "app", formatter).With("request", 7)
+		// off a TTY the default level is ERR
+		l.SetLevel(LevelWarn)
+		l.Error("Could not save", "i", -300, "u", uint64(1<<63+5), "f", 1.5, "f32", float32(2.25),
+			"b", true, "null", nil, "tm", tm, "raw", []byte{1, 2}, "ints", []int{1, -2},
+			"map", map[string]int{"a": 1}, "point", testPoint{1, 2.5}, "dur", time.Second,
+			"long", long, "err", errors.New("boom"))
+		l.Warn("second")
+
+		dec := newDecoder(&buf)
+		entry, err := dec.Decode()
+		assert.NoError(t, err, kind)
+		ts, ok := entry[KeyMap.Time].(time.Time)
+		assert.True(t, ok, kind)
+		assert.True(t, time.Since(ts) < time.Minute, kind)
+		assert.Equal(t, int64(pid), entry[KeyMap.PID], kind)
+		assert.Equal(t, "ERR", entry[KeyMap.Level], kind)
+		assert.Equal(t, "app", entry[KeyMap.Name], kind)
+		assert.Equal(t, "Could not save", entry[KeyMap.Message], kind)
+		assert.Equal(t, int64(7), entry["request"], kind)
+		assert.Equal(t, int64(-300), entry["i"], kind)
+		assert.Equal(t, uint64(1<<63+5), entry["u"], kind)
+		assert.Equal(t, 1.5, entry["f"], kind)
+		assert.Equal(t, 2.25, entry["f32"], kind)
+		assert.Equal(t, true, entry["b"], kind)
+		assert.Nil(t, entry["null"], kind)
+		decoded, ok := entry["tm"].(time.Time)
+		assert.True(t, ok && decoded.Equal(tm), kind)
+		assert.Equal(t, []byte{1, 2}, entry["raw"], kind)
+		assert.Equal(t, []interface{}{int64(1), int64(-2)}, entry["ints"], kind)
+		assert.Equal(t, map[string]interface{}{"a": int64(1)}, entry["map"], kind)
+		assert.Equal(t, map[string]interface{}{"x": int64(1), "y": 2.5}, entry["point"], kind)
+		assert.Equal(t, int64(time.Second), entry["dur"], kind)
+		assert.Equal(t, long, entry["long"], kind)
+		assert.Equal(t, "boom", entry["err"], kind)
+		assert.Contains(t, entry[KeyMap.CallStack], "goroutine", kind)
+
+		entry, err = dec.Decode()
+		assert.NoError(t, err, kind)
+		assert.Equal(t, "second", entry[KeyMap.Message], kind)
+		assert.Len(t, entry, 6, kind)
+		_, err = dec.Decode()
+		assert.Equal(t, io.EOF, err, kind)
+
+		buf.Reset()
+		l.Warn("truncated")
+		if assert.True(t, buf.Len() > 3, kind) {
+			_, err = newDecoder(bytes.NewReader(buf.Bytes()[:buf.Len()-3])).Decode()
+			assert.Equal(t, io.ErrUnexpectedEOF, err, kind)
+		}
+	}
+
+	// examples of RFC 8949 appendix A
+	for h, want := range map[string]interface{}{
+		"3903e7":             int64(-1000),
+		"1bffffffffffffffff": uint64(1<<64 - 1),
+		"f93c00":             1.0,
+		"f9c400":             -4.0,
+		"fb3ff199999999999a": 1.1,
+		"c11a514b67b0":       time.Unix(1363896240, 0),
+		"c074323031332d30332d32315432303a30343a30305a": time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC),
+		"9f018202039f0405ffff":                         []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}},
+		"7f657374726561646d696e67ff":                   "streaming",
+		"a201020304":                                   map[string]interface{}{"1": int64(2), "3": int64(4)},
+	} {
+		p, _ := hex.DecodeString(h)
+		v, err := cborEncoding{}.decode(bufio.NewReader(bytes.NewReader(p)), 0)
+		assert.NoError(t, err, h)
+		if tm, ok := want.(time.Time); ok {
+			assert.True(t, tm.Equal(v.(time.Time)), h)
+			continue
+		}
+		assert.Equal(t, want, v, h)
+	}
+
+	var buf bytes.Buffer
+	me := msgpackEncoding{}
+	me.writeInt(&buf, -33)
+	me.writeInt(&buf, -32)
+	me.writeUint(&buf, 200)
+	me.writeString(&buf, "a")
+	me.writeTime(&buf, time.Unix(1, 0))
+	me.writeMapHeader(&buf, 16)
+	assert.Equal(t, []byte{0xd0, 0xdf, 0xe0, 0xcc, 0xc8, 0xa1, 'a', 0xd6, 0xff, 0, 0, 0, 1, 0xde, 0, 16}, buf.Bytes())
+	buf.Reset()
+	ce := cborEncoding{}
+	ce.writeInt(&buf, -1000)
+	ce.writeString(&buf, "a")
+	ce.writeTime(&buf, time.Unix(1363896240, 0))
+	assert.Equal(t, []byte{0x39, 0x03, 0xe7, 0x61, 'a', 0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, buf.Bytes())
+
+	_, err := NewMsgpackDecoder(bytes.NewReader([]byte{0xdf, 0xff, 0xff, 0xff, 0xff})).Decode()
+	assert.Error(t, err, "length too large")
+	_, err = NewCBORDecoder(bytes.NewReader([]byte{0x01})).Decode()
+	assert.Error(t, err, "not a map")
+
+	// lengths in short headers do not allocate the elements up front
+	for _, input := range [][]byte{
+		{0x81, 0xdd, 0x03, 0xff, 0xff, 0xff},
+		{0x81, 0xdf, 0x03, 0xff, 0xff, 0xff},
+		{0xa1, 0x61, 'a', 0x9a, 0x03, 0xff, 0xff, 0xff},
+		{0xa1, 0x61, 'a', 0xba, 0x03, 0xff, 0xff, 0xff},
+	} {
+		decode := NewCBORDecoder
+		if input[0] == 0x81 {
+			decode = NewMsgpackDecoder
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err = decode(bytes.NewReader(input)).Decode()
+		runtime.ReadMemStats(&after)
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+		assert.True(t, after.TotalAlloc-before.TotalAlloc < 1<<20, "allocated %d bytes", after.TotalAlloc-before.TotalAlloc)
+	}
+}
+
+func TestOTelFormatter(t *testing.T) {
+	testResetEnv()
+	formatter := NewOTelFormatter("app")
+	assert.Equal(t, "unknown_service:"+filepath.Base(os.Args[0]), formatter.Resource[0].Value)
+	os.Setenv("OTEL_RESOURCE_ATTRIBUTES", "deployment.environment=prod,service.name=from%20attrs,bad")
+	formatter = NewOTelFormatter("app")
+	assert.Equal(t, Field{Key: "service.name", Value: "from attrs"}, formatter.Resource[0])
+	assert.Equal(t, Field{Key: "deployment.environment", Value: "prod"}, formatter.Resource[len(formatter.Resource)-1])
+	os.Setenv("OTEL_SERVICE_NAME", "checkout")
+	formatter = NewOTelFormatter("app")
+	assert.Equal(t, "checkout", formatter.Resource[0].Value)
+
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "app", formatter).With("trace_id", "4BF92F3577B34DA6A3CE929D0E0E4736", "user", "gopher")
+	l.Error("Could not save", "span_id", [8]byte{0, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}, "n", 42, "f", 1.5,
+		"b", true, "null", nil, "raw", []byte("hi"), "tags", []string{"a", "b"}, "m", map[string]int{"x": 1},
+		"point", testPoint{1, 2.5}, "err", errors.New("boom"))
+	line := buf.String()
+	assert.Equal(t, 1, strings.Count(line, "\n"), line)
+
+	var req struct {
+		ResourceLogs []struct {
+			Resource struct {
+				Attributes []map[string]interface{}
+			}
+			ScopeLogs []struct {
+				Scope      map[string]interface{}
+				LogRecords []map[string]interface{}
+			}
+		}
+	}
+	assert.NoError(t, json.Unmarshal([]byte(line), &req), line)
+	assert.Len(t, req.ResourceLogs, 1)
+	rl := req.ResourceLogs[0]
+	assert.Equal(t, map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "checkout"}}, rl.Resource.Attributes[0])
+	assert.Equal(t, map[string]interface{}{"key": "process.pid", "value": map[string]interface{}{"intValue": pidStr}}, rl.Resource.Attributes[1])
+	assert.Equal(t, "app", rl.ScopeLogs[0].Scope["name"])
+	record := rl.ScopeLogs[0].LogRecords[0]
+	assert.Equal(t, float64(17), record["severityNumber"])
+	assert.Equal(t, "ERR", record["severityText"])
+	assert.Equal(t, map[string]interface{}{"stringValue": "Could not save"}, record["body"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", record["traceId"])
+	assert.Equal(t, "00f067aa0ba902b7", record["spanId"])
+	ns, err := strconv.ParseInt(record["timeUnixNano"].(string), 10, 64)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(time.Unix(0, ns)) < time.Minute)
+
+	toJSON := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	attributes := map[string]interface{}{}
+	for _, attribute := range record["attributes"].([]interface{}) {
+		kv := attribute.(map[string]interface{})
+		attributes[kv["key"].(string)] = kv["value"]
+	}
+	assert.Nil(t, attributes["trace_id"])
+	assert.Nil(t, attributes["span_id"])
+	assert.Equal(t, map[string]interface{}{"stringValue": "gopher"}, attributes["user"])
+	assert.Equal(t, map[string]interface{}{"intValue": "42"}, attributes["n"])
+	assert.Equal(t, map[string]interface{}{"doubleValue": 1.5}, attributes["f"])
+	assert.Equal(t, map[string]interface{}{"boolValue": true}, attributes["b"])
+	assert.Equal(t, map[string]interface{}{}, attributes["null"])
+	assert.Equal(t, map[string]interface{}{"bytesValue": "aGk="}, attributes["raw"])
+	assert.Equal(t, `{"arrayValue":{"values":[{"stringValue":"a"},{"stringValue":"b"}]}}`, toJSON(attributes["tags"]))
+	assert.Equal(t, `{"kvlistValue":{"values":[{"key":"x","value":{"intValue":"1"}}]}}`, toJSON(attributes["m"]))
+	assert.Equal(t, `{"kvlistValue":{"values":[{"key":"x","value":{"intValue":"1"}},{"key":"y","value":{"doubleValue":2.5}}]}}`, toJSON(attributes["point"]))
+	assert.Equal(t, map[string]interface{}{"stringValue": "boom"}, attributes["err"])
+	assert.Contains(t, toJSON(attributes["exception.stacktrace"]), "goroutine")
+	assert.Contains(t, toJSON(attributes["code.function"]), "TestOTelFormatter")
+
+	for level, severity := range map[Level]int{LevelTrace: 1, LevelDebug: 5, LevelInfo: 9, LevelNotice: 10, LevelWarn: 13, LevelCritical: 22, LevelAlert: 23, LevelFatal: 21, LevelEmergency: 24, -5: 10} {
+		assert.Equal(t, severity, otelSeverity(level), level)
+	}
+
+	formatter2, err := createFormatter("app", FormatOTel)
+	assert.NoError(t, err)
+	_, ok := formatter2.(*OTelFormatter)
+	assert.True(t, ok)
+}
+
+func TestOTLPBatchWriter(t *testing.T) {
+	testResetEnv()
+	var buf bytes.Buffer
+	bw := NewOTLPBatchWriter(&buf, 3, 0)
+	app := NewLogger3(bw, "app", NewOTelFormatter("app"))
+	db := NewLogger3(bw, "db", NewOTelFormatter("db"))
+	app.Error("one")
+	db.Error("two")
+	assert.Equal(t, 0, buf.Len(), "batch is not full")
+	app.Error("three")
+
+	var req otlpRequest
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &req))
+	assert.Len(t, req.ResourceLogs, 1)
+	assert.Len(t, req.ResourceLogs[0].ScopeLogs, 2)
+	assert.Len(t, req.ResourceLogs[0].ScopeLogs[0].LogRecords, 2)
+	assert.Contains(t, string(req.ResourceLogs[0].ScopeLogs[0].LogRecords[1]), "three")
+	assert.Len(t, req.ResourceLogs[0].ScopeLogs[1].LogRecords, 1)
+
+	buf.Reset()
+	db.Error("four")
+	assert.NoError(t, bw.Flush())
+	assert.Contains(t, buf.String(), "four")
+	buf.Reset()
+	assert.NoError(t, bw.Flush())
+	assert.Equal(t, 0, buf.Len(), "empty batches are not written")
+
+	_, err := bw.Write([]byte("not json"))
+	assert.Error(t, err)
+
+	sw := &slowWriter{release: make(chan struct{})}
+	close(sw.release)
+	bw = NewOTLPBatchWriter(sw, 100, 10*time.Millisecond)
+	NewLogger3(bw, "app", NewOTelFormatter("app")).Error("timed")
+	assert.True(t, waitFor(func() bool { return strings.Contains(sw.String(), "timed") }))
+	assert.NoError(t, bw.Close())
+	_, err = bw.Write([]byte("{}"))
+	assert.Equal(t, ErrWriterClosed, err)
+}
+
+func TestECSFormatter(t *testing.T) {
+	testResetEnv()
+	os.Setenv("LOGXI_FORMAT", "ecs,namespace=app")
+	processEnv()
+	formatter, err := createFormatter("checkout", FormatEnv)
+	assert.NoError(t, err)
+	ef, ok := formatter.(*ECSFormatter)
+	assert.True(t, ok)
+	assert.Equal(t, "app", ef.Namespace)
+	ef.KeyTable = map[string]string{"user": "user.name", "method": "http.request.method"}
+
+	var buf bytes.Buffer
+	l := NewLogger3(&buf, "checkout", ef).With("user", "gopher")
+	l.Error("Could not save", "method", "POST", "cart.items", 3, "cart.total", 9.5, "message", "ignored",
+		"err", errors.New("boom"), "err2", errors.New("second"))
+	line := buf.String()
+	assert.Equal(t, 1, strings.Count(line, "\n"), line)
+	assert.True(t, strings.HasPrefix(line, `{"@timestamp":"`), line)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(line), &doc), line)
+	ts, err := time.Parse(time.RFC3339Nano, doc["@timestamp"].(string))
+	assert.NoError(t, err)
+	assert.True(t, time.Since(ts) < time.Minute)
+	assert.Equal(t, "Could not save", doc["message"])
+	assert.Equal(t, map[string]interface{}{"version": ECSVersion}, doc["ecs"])
+	assert.Equal(t, map[string]interface{}{"pid": float64(pid)}, doc["process"])
+	assert.Equal(t, map[string]interface{}{"name": "gopher"}, doc["user"])
+	assert.Equal(t, map[string]interface{}{"request": map[string]interface{}{"method": "POST"}}, doc["http"])
+	assert.Equal(t, map[string]interface{}{
+		"cart":    map[string]interface{}{"items": float64(3), "total": 9.5},
+		"message": "ignored",
+		"err2":    "second",
+	}, doc["app"])
+
+	logDoc := doc["log"].(map[string]interface{})
+	assert.Equal(t, "error", logDoc["level"])
+	assert.Equal(t, "checkout", logDoc["logger"])
+	assert.Contains(t, logDoc["origin"].(map[string]interface{})["function"], "TestECSFormatter")
+
+	errDoc := doc["error"].(map[string]interface{})
+	assert.Equal(t, "boom", errDoc["message"])
+	assert.Equal(t, "*errors.errorString", errDoc["type"])
+	assert.Contains(t, errDoc["stack_trace"], "goroutine")
+
+	// top-level pairs do not replace ECS fields
+	buf.Reset()
+	ef = NewECSFormatter("app")
+	ef.Namespace = ""
+	NewLogger3(&buf, "app", ef).Error("hi", "log", "x", "message.text", "y", "labels.env", "prod")
+	doc = nil
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &doc), buf.String())
+	assert.Equal(t, "hi", doc["message"])
+	assert.Equal(t, "error", doc["log"].(map[string]interface{})["level"])
+	assert.Equal(t, map[string]interface{}{"env": "prod"}, doc["labels"])
+}
@@ -0,0 +1,129 @@
+package log
+
+import (
+	"io"
+	"runtime/debug"
+	"strings"
+)
+
+const ltsvAssignmentChar = ":"
+const ltsvSeparator = "\t"
+
+// LTSVFormatter formats entries as Labeled Tab-separated Values
+// (http://ltsv.org), eg
+//
+//     _t:2016-01-02T03:04:05-0700<TAB>_p:123<TAB>_n:app<TAB>_l:ERR<TAB>_m:Could not save<TAB>err:boom<TAB>_c:goroutine 1 [running]:\n...
+//
+// It owns its separators, so it can be used alongside TextFormatter in the
+// same process. Labels have only the characters allowed by the spec and
+// tabs, newlines and backslashes in values are escaped as \t, \n, \r and
+// \\, so each entry is one line.
+type LTSVFormatter struct {
+	name string
+	// context is the pre-encoded key-value pairs bound with Logger.With
+	context string
+}
+
+// NewLTSVFormatter creates a new LTSVFormatter.
+func NewLTSVFormatter(name string) *LTSVFormatter {
+	return &LTSVFormatter{name: name}
+}
+
+// WithContext returns a new LTSVFormatter which writes the pre-encoded
+// key-value pairs args with every entry.
+func (lf *LTSVFormatter) WithContext(args []interface{}) Formatter {
+	buf := pool.Get()
+	defer pool.Put(buf)
+	buf.WriteString(lf.context)
+	fields := argsToFields(args)
+	var stack string
+	if hasError(fields) {
+		stack = string(debug.Stack())
+	}
+	lf.setFields(buf, fields, stack)
+	return &LTSVFormatter{name: lf.name, context: buf.String()}
+}
+
+// WithName returns a new LTSVFormatter for name.
+func (lf *LTSVFormatter) WithName(name string) Formatter {
+	return &LTSVFormatter{name: name, context: lf.context}
+}
+
+// Format formats a log entry as LTSV.
+func (lf *LTSVFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	lf.FormatEntry(writer, NewEntry(level, lf.name, msg, args))
+}
+
+// FormatEntry formats entry as an LTSV line.
+func (lf *LTSVFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	buf := pool.Get()
+	defer pool.Put(buf)
+
+	buf.WriteString(ltsvLabel(KeyMap.Time))
+	buf.WriteString(ltsvAssignmentChar)
+	writeEscapedTSV(buf, entry.Time.Format(settings().timeFormat))
+	lf.set(buf, KeyMap.PID, pidStr)
+	lf.set(buf, KeyMap.Name, lf.name)
+	lf.set(buf, KeyMap.Level, entry.Level.String())
+	lf.set(buf, KeyMap.Message, entry.Message)
+	buf.WriteString(lf.context)
+	lf.setFields(buf, entry.Fields, entry.Stack)
+	buf.WriteByte('\n')
+	buf.WriteTo(writer)
+}
+
+func (lf *LTSVFormatter) set(buf bufferWriter, key string, value string) {
+	buf.WriteString(ltsvSeparator)
+	buf.WriteString(ltsvLabel(key))
+	buf.WriteString(ltsvAssignmentChar)
+	writeEscapedTSV(buf, value)
+}
+
+// setFields writes fields. The stack is written after errors.
+func (lf *LTSVFormatter) setFields(buf bufferWriter, fields []Field, stack string) {
+	for _, field := range fields {
+		lf.set(buf, field.Key, valueString(field.Value))
+		if _, ok := field.Value.(error); ok {
+			lf.set(buf, KeyMap.CallStack, stack)
+		}
+	}
+}
+
+// ltsvLabel returns key as an LTSV label, which has only letters, digits,
+// '_', '.' and '-'.
+func ltsvLabel(key string) string {
+	if key == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// writeEscapedTSV writes s escaping backslashes, tabs and line breaks.
+func writeEscapedTSV(buf bufferWriter, s string) {
+	start := 0
+	for i := 0; i < len(s); i++ {
+		var esc string
+		switch s[i] {
+		case '\\':
+			esc = `\\`
+		case '\t':
+			esc = `\t`
+		case '\n':
+			esc = `\n`
+		case '\r':
+			esc = `\r`
+		default:
+			continue
+		}
+		buf.WriteString(s[start:i])
+		buf.WriteString(esc)
+		start = i + 1
+	}
+	buf.WriteString(s[start:])
+}
@@ -15,6 +15,11 @@ func Info(msg string, args ...interface{}) {
 	DefaultLog.Info(msg, args...)
 }
 
+// Notice logs a notice statement.
+func Notice(msg string, args ...interface{}) {
+	DefaultLog.Notice(msg, args...)
+}
+
 // Warn logs a warning statement. On terminals it logs file and line number.
 func Warn(msg string, args ...interface{}) {
 	DefaultLog.Warn(msg, args...)
@@ -25,6 +30,21 @@ func Error(msg string, args ...interface{}) {
 	DefaultLog.Error(msg, args...)
 }
 
+// Critical logs a critical statement with callstack.
+func Critical(msg string, args ...interface{}) {
+	DefaultLog.Critical(msg, args...)
+}
+
+// Alert logs an alert statement with callstack.
+func Alert(msg string, args ...interface{}) {
+	DefaultLog.Alert(msg, args...)
+}
+
+// Emergency logs an emergency statement with callstack.
+func Emergency(msg string, args ...interface{}) {
+	DefaultLog.Emergency(msg, args...)
+}
+
 // Fatal logs a fatal statement.
 func Fatal(msg string, args ...interface{}) {
 	DefaultLog.Fatal(msg, args...)
@@ -45,7 +65,17 @@ func IsInfo() bool {
 	return DefaultLog.IsInfo()
 }
 
+// IsNotice determines if this logger logs a notice statement.
+func IsNotice() bool {
+	return DefaultLog.IsNotice()
+}
+
 // IsWarn determines if this logger logs a warning statement.
 func IsWarn() bool {
 	return DefaultLog.IsWarn()
 }
+
+// IsError determines if this logger logs an error statement.
+func IsError() bool {
+	return DefaultLog.IsError()
+}
@@ -0,0 +1,311 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// msgpackTimestamp is the extension type of timestamps
+const msgpackTimestamp = -1
+
+// msgpackEncoding encodes and decodes MessagePack. Times are the timestamp
+// extension type with nanosecond precision.
+type msgpackEncoding struct{}
+
+// writeHead writes the code followed by n in size bytes.
+func (msgpackEncoding) writeHead(buf *bytes.Buffer, code byte, n uint64, size int) {
+	var b [9]byte
+	b[0] = code
+	switch size {
+	case 1:
+		b[1] = byte(n)
+	case 2:
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+	case 4:
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+	case 8:
+		binary.BigEndian.PutUint64(b[1:], n)
+	}
+	buf.Write(b[:1+size])
+}
+
+func (msgpackEncoding) writeNil(buf *bytes.Buffer) {
+	buf.WriteByte(0xc0)
+}
+
+func (msgpackEncoding) writeBool(buf *bytes.Buffer, v bool) {
+	if v {
+		buf.WriteByte(0xc3)
+	} else {
+		buf.WriteByte(0xc2)
+	}
+}
+
+func (me msgpackEncoding) writeInt(buf *bytes.Buffer, v int64) {
+	switch {
+	case v >= 0:
+		me.writeUint(buf, uint64(v))
+	case v >= -32:
+		buf.WriteByte(byte(v))
+	case v >= math.MinInt8:
+		me.writeHead(buf, 0xd0, uint64(v), 1)
+	case v >= math.MinInt16:
+		me.writeHead(buf, 0xd1, uint64(v), 2)
+	case v >= math.MinInt32:
+		me.writeHead(buf, 0xd2, uint64(v), 4)
+	default:
+		me.writeHead(buf, 0xd3, uint64(v), 8)
+	}
+}
+
+func (me msgpackEncoding) writeUint(buf *bytes.Buffer, v uint64) {
+	switch {
+	case v < 128:
+		buf.WriteByte(byte(v))
+	case v <= math.MaxUint8:
+		me.writeHead(buf, 0xcc, v, 1)
+	case v <= math.MaxUint16:
+		me.writeHead(buf, 0xcd, v, 2)
+	case v <= math.MaxUint32:
+		me.writeHead(buf, 0xce, v, 4)
+	default:
+		me.writeHead(buf, 0xcf, v, 8)
+	}
+}
+
+func (me msgpackEncoding) writeFloat32(buf *bytes.Buffer, v float32) {
+	me.writeHead(buf, 0xca, uint64(math.Float32bits(v)), 4)
+}
+
+func (me msgpackEncoding) writeFloat64(buf *bytes.Buffer, v float64) {
+	me.writeHead(buf, 0xcb, math.Float64bits(v), 8)
+}
+
+// writeLength writes the code of a string, bin, array or map of length n.
+// fix is the code of the fix format, or 0 if there is none, and fixMax is
+// its maximum length.
+func (me msgpackEncoding) writeLength(buf *bytes.Buffer, n int, fix byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case fix != 0 && n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		me.writeHead(buf, code8, uint64(n), 1)
+	case n <= math.MaxUint16:
+		me.writeHead(buf, code16, uint64(n), 2)
+	default:
+		me.writeHead(buf, code32, uint64(n), 4)
+	}
+}
+
+func (me msgpackEncoding) writeString(buf *bytes.Buffer, s string) {
+	me.writeLength(buf, len(s), 0xa0, 31, 0xd9, 0xda, 0xdb)
+	buf.WriteString(s)
+}
+
+func (me msgpackEncoding) writeBytes(buf *bytes.Buffer, p []byte) {
+	me.writeLength(buf, len(p), 0, 0, 0xc4, 0xc5, 0xc6)
+	buf.Write(p)
+}
+
+// writeTime writes the timestamp 32, 64 or 96 format.
+func (msgpackEncoding) writeTime(buf *bytes.Buffer, t time.Time) {
+	secs, nsecs := t.Unix(), uint64(t.Nanosecond())
+	var b [15]byte
+	switch {
+	case secs>>34 == 0 && nsecs == 0:
+		b[0], b[1] = 0xd6, 0xff
+		binary.BigEndian.PutUint32(b[2:], uint32(secs))
+		buf.Write(b[:6])
+	case secs>>34 == 0:
+		b[0], b[1] = 0xd7, 0xff
+		binary.BigEndian.PutUint64(b[2:], nsecs<<34|uint64(secs))
+		buf.Write(b[:10])
+	default:
+		b[0], b[1], b[2] = 0xc7, 12, 0xff
+		binary.BigEndian.PutUint32(b[3:], uint32(nsecs))
+		binary.BigEndian.PutUint64(b[7:], uint64(secs))
+		buf.Write(b[:15])
+	}
+}
+
+func (me msgpackEncoding) writeArrayHeader(buf *bytes.Buffer, n int) {
+	me.writeLength(buf, n, 0x90, 15, 0, 0xdc, 0xdd)
+}
+
+func (me msgpackEncoding) writeMapHeader(buf *bytes.Buffer, n int) {
+	me.writeLength(buf, n, 0x80, 15, 0, 0xde, 0xdf)
+}
+
+// readUint reads a big-endian unsigned integer of size bytes.
+func (msgpackEncoding) readUint(r *bufio.Reader, size int) (uint64, error) {
+	var p [8]byte
+	if _, err := readFull(r, p[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(p[:]), nil
+}
+
+// decode reads a value. Integers are int64, or uint64 if larger than
+// math.MaxInt64, floats are float64, timestamps are time.Time, bins and
+// other extension types are []byte, arrays are []interface{} and maps are
+// map[string]interface{}.
+func (me msgpackEncoding) decode(r *bufio.Reader, depth int) (interface{}, error) {
+	if depth > maxValueDepth {
+		return nil, errBinaryDepth
+	}
+	code, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xe0 == 0xa0:
+		p, err := readBinaryLength(r, uint64(code&0x1f))
+		return string(p), err
+	case code&0xf0 == 0x90:
+		return me.decodeArray(r, uint64(code&0x0f), depth)
+	case code&0xf0 == 0x80:
+		return me.decodeMap(r, uint64(code&0x0f), depth)
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := me.readUint(r, 1<<(code-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		n, err := me.readUint(r, size)
+		if err != nil {
+			return nil, err
+		}
+		// sign extend
+		shift := uint(64 - 8*size)
+		return int64(n<<shift) >> shift, nil
+	case 0xca:
+		n, err := me.readUint(r, 4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := me.readUint(r, 8)
+		return math.Float64frombits(n), err
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		var size int
+		switch code {
+		case 0xd9, 0xc4:
+			size = 1
+		case 0xda, 0xc5:
+			size = 2
+		default:
+			size = 4
+		}
+		n, err := me.readUint(r, size)
+		if err != nil {
+			return nil, err
+		}
+		p, err := readBinaryLength(r, n)
+		if err != nil {
+			return nil, err
+		}
+		if code >= 0xd9 {
+			return string(p), nil
+		}
+		return p, nil
+	case 0xdc, 0xdd:
+		n, err := me.readUint(r, 2<<(code-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return me.decodeArray(r, n, depth)
+	case 0xde, 0xdf:
+		n, err := me.readUint(r, 2<<(code-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return me.decodeMap(r, n, depth)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return me.decodeExt(r, uint64(1)<<(code-0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := me.readUint(r, 1<<(code-0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return me.decodeExt(r, n)
+	}
+	return nil, fmt.Errorf("msgpack: invalid code 0x%x", code)
+}
+
+func (me msgpackEncoding) decodeArray(r *bufio.Reader, n uint64, depth int) (interface{}, error) {
+	if err := checkBinaryLength(n); err != nil {
+		return nil, err
+	}
+	a := make([]interface{}, 0, preallocLength(n))
+	for i := uint64(0); i < n; i++ {
+		v, err := me.decode(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func (me msgpackEncoding) decodeMap(r *bufio.Reader, n uint64, depth int) (interface{}, error) {
+	if err := checkBinaryLength(n); err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{}, preallocLength(n))
+	for i := uint64(0); i < n; i++ {
+		k, err := me.decode(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := me.decode(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		m[binaryKey(k)] = v
+	}
+	return m, nil
+}
+
+// decodeExt reads the type and n bytes of data of an extension type.
+func (me msgpackEncoding) decodeExt(r *bufio.Reader, n uint64) (interface{}, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	p, err := readBinaryLength(r, n)
+	if err != nil || int8(typ) != msgpackTimestamp {
+		return p, err
+	}
+
+	switch len(p) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(p)), 0), nil
+	case 8:
+		n := binary.BigEndian.Uint64(p)
+		return time.Unix(int64(n&(1<<34-1)), int64(n>>34)), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(p[4:])), int64(binary.BigEndian.Uint32(p))), nil
+	}
+	return nil, fmt.Errorf("msgpack: invalid timestamp of %d bytes", len(p))
+}
@@ -1,10 +1,25 @@
 package log
 
+import "context"
+
 // NullLog is a noop logger. Think of it as /dev/null.
 var NullLog = &NullLogger{}
 
 // NullLogger is the default logger for this package.
-type NullLogger struct{}
+type NullLogger struct {
+	// fatalFunc replaces the fatal policy of loggers passed by CaptureFatal
+	fatalFunc FatalFunc
+}
+
+// With returns the null logger.
+func (l *NullLogger) With(args ...interface{}) Logger {
+	return l
+}
+
+// Named returns the null logger.
+func (l *NullLogger) Named(sub string) Logger {
+	return l
+}
 
 // Trace logs a debug entry.
 func (l *NullLogger) Trace(msg string, args ...interface{}) {
@@ -18,6 +33,10 @@ func (l *NullLogger) Debug(msg string, args ...interface{}) {
 func (l *NullLogger) Info(msg string, args ...interface{}) {
 }
 
+// Notice logs a notice entry.
+func (l *NullLogger) Notice(msg string, args ...interface{}) {
+}
+
 // Warn logs a warn entry.
 func (l *NullLogger) Warn(msg string, args ...interface{}) error {
 	return nil
@@ -28,13 +47,78 @@ func (l *NullLogger) Error(msg string, args ...interface{}) error {
 	return nil
 }
 
-// Fatal logs a fatal entry then panics.
+// Critical logs a critical entry.
+func (l *NullLogger) Critical(msg string, args ...interface{}) error {
+	return nil
+}
+
+// Alert logs an alert entry.
+func (l *NullLogger) Alert(msg string, args ...interface{}) error {
+	return nil
+}
+
+// Emergency logs an emergency entry.
+func (l *NullLogger) Emergency(msg string, args ...interface{}) error {
+	return nil
+}
+
+// Fatal applies the fatal policy without logging.
 func (l *NullLogger) Fatal(msg string, args ...interface{}) {
-	panic("exit due to fatal error")
+	fatal(nil, msg, firstError(args), l.fatalFunc)
 }
 
 // Log logs a leveled entry.
-func (l *NullLogger) Log(level int, msg string, args []interface{}) {
+func (l *NullLogger) Log(level Level, msg string, args []interface{}) {
+}
+
+// TraceCtx logs a trace entry with context.
+func (l *NullLogger) TraceCtx(ctx context.Context, msg string, args ...interface{}) {
+}
+
+// DebugCtx logs a debug entry with context.
+func (l *NullLogger) DebugCtx(ctx context.Context, msg string, args ...interface{}) {
+}
+
+// InfoCtx logs an info entry with context.
+func (l *NullLogger) InfoCtx(ctx context.Context, msg string, args ...interface{}) {
+}
+
+// NoticeCtx logs a notice entry with context.
+func (l *NullLogger) NoticeCtx(ctx context.Context, msg string, args ...interface{}) {
+}
+
+// WarnCtx logs a warn entry with context.
+func (l *NullLogger) WarnCtx(ctx context.Context, msg string, args ...interface{}) error {
+	return nil
+}
+
+// ErrorCtx logs an error entry with context.
+func (l *NullLogger) ErrorCtx(ctx context.Context, msg string, args ...interface{}) error {
+	return nil
+}
+
+// CriticalCtx logs a critical entry with context.
+func (l *NullLogger) CriticalCtx(ctx context.Context, msg string, args ...interface{}) error {
+	return nil
+}
+
+// AlertCtx logs an alert entry with context.
+func (l *NullLogger) AlertCtx(ctx context.Context, msg string, args ...interface{}) error {
+	return nil
+}
+
+// EmergencyCtx logs an emergency entry with context.
+func (l *NullLogger) EmergencyCtx(ctx context.Context, msg string, args ...interface{}) error {
+	return nil
+}
+
+// FatalCtx applies the fatal policy without logging.
+func (l *NullLogger) FatalCtx(ctx context.Context, msg string, args ...interface{}) {
+	fatal(nil, msg, firstError(args), l.fatalFunc)
+}
+
+// LogCtx logs a leveled entry with context.
+func (l *NullLogger) LogCtx(ctx context.Context, level Level, msg string, args []interface{}) {
 }
 
 // IsTrace determines if this logger logs a trace statement.
@@ -52,13 +136,23 @@ func (l *NullLogger) IsInfo() bool {
 	return false
 }
 
+// IsNotice determines if this logger logs a notice statement.
+func (l *NullLogger) IsNotice() bool {
+	return false
+}
+
 // IsWarn determines if this logger logs a warning statement.
 func (l *NullLogger) IsWarn() bool {
 	return false
 }
 
+// IsError determines if this logger logs an error statement.
+func (l *NullLogger) IsError() bool {
+	return false
+}
+
 // SetLevel sets the level of this logger.
-func (l *NullLogger) SetLevel(level int) {
+func (l *NullLogger) SetLevel(level Level) {
 }
 
 // SetFormatter set the formatter for this logger.
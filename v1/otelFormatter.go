@@ -0,0 +1,652 @@
+package log
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otelSeverity maps a level to an OpenTelemetry severity number. Custom
+// levels more severe than LevelAlert are notices as in syslog.
+func otelSeverity(level Level) int {
+	switch level {
+	case LevelEmergency:
+		return 24 // FATAL4
+	case LevelAlert:
+		return 23 // FATAL3
+	case LevelCritical:
+		return 22 // FATAL2
+	case LevelFatal:
+		return 21 // FATAL
+	case LevelError:
+		return 17 // ERROR
+	case LevelWarn:
+		return 13 // WARN
+	case LevelNotice:
+		return 10 // INFO2
+	case LevelInfo:
+		return 9 // INFO
+	case LevelDebug:
+		return 5 // DEBUG
+	}
+	if level < LevelAlert {
+		return 10
+	}
+	return 1 // TRACE
+}
+
+// OTelFormatter formats entries as OTLP/JSON ExportLogsServiceRequests of
+// the OpenTelemetry logs data model, one request with one LogRecord per
+// line. The message is the Body, key-value pairs are typed Attributes and
+// the logger name is the instrumentation scope. Errors are followed by an
+// exception.stacktrace attribute. Wrap the writer with OTLPBatchWriter to
+// write batches of records.
+type OTelFormatter struct {
+	name string
+	// Resource is the attributes of the resource. It defaults to
+	// service.name, process.pid, host.name and OTEL_RESOURCE_ATTRIBUTES.
+	Resource []Field
+	// TraceIDKey is the key of the field which is the TraceId, eg a key
+	// registered with RegisterContextKey. It is a hex string or 16 bytes.
+	TraceIDKey string
+	// SpanIDKey is the key of the field which is the SpanId. It is a hex
+	// string or 8 bytes.
+	SpanIDKey string
+
+	// context is the pre-encoded attributes bound with Logger.With
+	context string
+	traceID string
+	spanID  string
+}
+
+// NewOTelFormatter creates a new OTelFormatter. service.name is
+// OTEL_SERVICE_NAME, the service.name of OTEL_RESOURCE_ATTRIBUTES or
+// "unknown_service:" and the name of the executable, as in the OpenTelemetry
+// SDKs.
+//
+// Example
+// log.RegisterContextKey(traceIDKey, "trace_id")
+// logger := log.NewLogger3(os.Stdout, "app", log.NewOTelFormatter("app"))
+func NewOTelFormatter(name string) *OTelFormatter {
+	attributes := parseOTelResourceAttributes(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	resource := []Field{{Key: "service.name"}, {Key: "process.pid", Value: pid}}
+	if hostname, err := os.Hostname(); err == nil {
+		resource = append(resource, Field{Key: "host.name", Value: hostname})
+	}
+	for _, field := range attributes {
+		if field.Key == "service.name" {
+			if service == "" {
+				service = field.Value.(string)
+			}
+			continue
+		}
+		resource = append(resource, field)
+	}
+	if service == "" {
+		service = "unknown_service:" + filepath.Base(os.Args[0])
+	}
+	resource[0].Value = service
+
+	return &OTelFormatter{
+		name:       name,
+		Resource:   resource,
+		TraceIDKey: "trace_id",
+		SpanIDKey:  "span_id",
+	}
+}
+
+// parseOTelResourceAttributes parses percent-encoded key=value pairs
+// separated by commas.
+func parseOTelResourceAttributes(s string) []Field {
+	var fields []Field
+	for _, pair := range strings.Split(s, ",") {
+		idx := strings.Index(pair, "=")
+		if idx < 1 {
+			continue
+		}
+		key, err := url.PathUnescape(strings.TrimSpace(pair[:idx]))
+		if err != nil {
+			continue
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(pair[idx+1:]))
+		if err != nil {
+			continue
+		}
+		fields = append(fields, Field{Key: key, Value: value})
+	}
+	return fields
+}
+
+// WithContext returns a new OTelFormatter which writes the pre-encoded
+// attributes args with every entry.
+func (of *OTelFormatter) WithContext(args []interface{}) Formatter {
+	buf := pool.Get()
+	defer pool.Put(buf)
+	buf.WriteString(of.context)
+	fields := argsToFields(args)
+	var stack string
+	if hasError(fields) {
+		stack = string(debug.Stack())
+	}
+	c := *of
+	traceID, spanID := of.setFields(buf, fields, stack)
+	if traceID != "" {
+		c.traceID = traceID
+	}
+	if spanID != "" {
+		c.spanID = spanID
+	}
+	c.context = buf.String()
+	return &c
+}
+
+// WithName returns a copy of the formatter for name.
+func (of *OTelFormatter) WithName(name string) Formatter {
+	c := *of
+	c.name = name
+	return &c
+}
+
+// Format formats a log entry as OTLP/JSON.
+func (of *OTelFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	of.FormatEntry(writer, NewEntry(level, of.name, msg, args))
+}
+
+// FormatEntry formats entry as an ExportLogsServiceRequest terminated by a
+// newline.
+func (of *OTelFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	buf := pool.Get()
+	defer pool.Put(buf)
+	attributes := pool.Get()
+	defer pool.Put(attributes)
+
+	buf.WriteString(`{"resourceLogs":[{"resource":{"attributes":[`)
+	for _, field := range of.Resource {
+		of.set(attributes, field.Key, field.Value)
+	}
+	writeOTelList(buf, attributes)
+	buf.WriteString(`]},"scopeLogs":[{"scope":{"name":`)
+	valueFormatter.writeString(buf, of.name)
+
+	ns := strconv.FormatInt(entry.Time.UnixNano(), 10)
+	buf.WriteString(`},"logRecords":[{"timeUnixNano":"`)
+	buf.WriteString(ns)
+	buf.WriteString(`","observedTimeUnixNano":"`)
+	buf.WriteString(ns)
+	buf.WriteString(`","severityNumber":`)
+	buf.WriteString(strconv.Itoa(otelSeverity(entry.Level)))
+	buf.WriteString(`,"severityText":`)
+	valueFormatter.writeString(buf, entry.Level.String())
+	buf.WriteString(`,"body":{"stringValue":`)
+	valueFormatter.writeString(buf, entry.Message)
+
+	buf.WriteString(`},"attributes":[`)
+	attributes.Reset()
+	attributes.WriteString(of.context)
+	traceID, spanID := of.setFields(attributes, entry.Fields, entry.Stack)
+	if entry.Caller != nil {
+		of.set(attributes, "code.filepath", entry.Caller.File)
+		of.set(attributes, "code.lineno", entry.Caller.Line)
+		of.set(attributes, "code.function", entry.Caller.Function)
+	}
+	writeOTelList(buf, attributes)
+	buf.WriteByte(']')
+
+	if traceID == "" {
+		traceID = of.traceID
+	}
+	if spanID == "" {
+		spanID = of.spanID
+	}
+	if traceID != "" {
+		buf.WriteString(`,"traceId":"`)
+		buf.WriteString(traceID)
+		buf.WriteByte('"')
+	}
+	if spanID != "" {
+		buf.WriteString(`,"spanId":"`)
+		buf.WriteString(spanID)
+		buf.WriteByte('"')
+	}
+	buf.WriteString("}]}]}]}\n")
+	buf.WriteTo(writer)
+}
+
+// writeOTelList writes the elements in list, each of which is preceded by
+// a comma.
+func writeOTelList(buf *bytes.Buffer, list *bytes.Buffer) {
+	if list.Len() > 0 {
+		buf.Write(list.Bytes()[1:])
+	}
+}
+
+// setFields writes fields as attributes and returns the trace and span ids
+// of fields, which are not attributes. The stack is written after errors.
+func (of *OTelFormatter) setFields(buf *bytes.Buffer, fields []Field, stack string) (traceID, spanID string) {
+	for _, field := range fields {
+		if field.Key == of.TraceIDKey {
+			if id, ok := otelID(field.Value, 16); ok {
+				traceID = id
+				continue
+			}
+		}
+		if field.Key == of.SpanIDKey {
+			if id, ok := otelID(field.Value, 8); ok {
+				spanID = id
+				continue
+			}
+		}
+		of.set(buf, field.Key, field.Value)
+		if _, ok := field.Value.(error); ok {
+			of.set(buf, "exception.stacktrace", stack)
+		}
+	}
+	return traceID, spanID
+}
+
+// otelID returns value as the hex id of size bytes if it is a hex string,
+// or a byte slice or array, of that size which is not all zeros.
+func otelID(value interface{}, size int) (string, bool) {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = strings.ToLower(v)
+	case []byte:
+		s = hex.EncodeToString(v)
+	case fmt.Stringer:
+		s = strings.ToLower(v.String())
+	default:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Array || rv.Type().Elem().Kind() != reflect.Uint8 {
+			return "", false
+		}
+		p := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(p), rv)
+		s = hex.EncodeToString(p)
+	}
+	if len(s) != 2*size || strings.Trim(s, "0") == "" {
+		return "", false
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// set writes a KeyValue preceded by a comma.
+func (of *OTelFormatter) set(buf *bytes.Buffer, key string, value interface{}) {
+	buf.WriteString(`,{"key":`)
+	valueFormatter.writeString(buf, key)
+	buf.WriteString(`,"value":`)
+	of.appendValue(buf, value, 0)
+	buf.WriteByte('}')
+}
+
+// appendValue writes val as an AnyValue.
+func (of *OTelFormatter) appendValue(buf *bytes.Buffer, val interface{}, depth int) {
+	switch v := val.(type) {
+	case nil:
+		buf.WriteString("{}")
+		return
+	case error:
+		of.writeStringValue(buf, v.Error())
+		return
+	case string:
+		of.writeStringValue(buf, v)
+		return
+	case time.Time:
+		of.writeStringValue(buf, v.Format(time.RFC3339Nano))
+		return
+	case []byte:
+		of.writeBytesValue(buf, v)
+		return
+	}
+
+	value := reflect.ValueOf(val)
+	kind := value.Kind()
+	if kind == reflect.Ptr {
+		if value.IsNil() {
+			buf.WriteString("{}")
+			return
+		}
+		value = value.Elem()
+		kind = value.Kind()
+	}
+	switch kind {
+	case reflect.Bool:
+		buf.WriteString(`{"boolValue":`)
+		buf.WriteString(strconv.FormatBool(value.Bool()))
+		buf.WriteByte('}')
+		return
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		of.writeIntValue(buf, strconv.FormatInt(value.Int(), 10))
+		return
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if value.Uint() > math.MaxInt64 {
+			of.writeStringValue(buf, strconv.FormatUint(value.Uint(), 10))
+		} else {
+			of.writeIntValue(buf, strconv.FormatUint(value.Uint(), 10))
+		}
+		return
+	case reflect.Float32, reflect.Float64:
+		of.writeDoubleValue(buf, value.Float())
+		return
+	}
+
+	if stringer, ok := val.(fmt.Stringer); ok {
+		of.writeStringValue(buf, stringer.String())
+		return
+	}
+	if depth >= maxValueDepth {
+		of.writeStringValue(buf, fmt.Sprintf("%v", val))
+		return
+	}
+
+	switch kind {
+	case reflect.String:
+		of.writeStringValue(buf, value.String())
+	case reflect.Slice, reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 && kind == reflect.Slice {
+			of.writeBytesValue(buf, value.Bytes())
+			return
+		}
+		buf.WriteString(`{"arrayValue":{"values":[`)
+		for i := 0; i < value.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			of.appendValue(buf, value.Index(i).Interface(), depth+1)
+		}
+		buf.WriteString("]}}")
+	case reflect.Map:
+		keys := value.MapKeys()
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = fmt.Sprintf("%v", key.Interface())
+		}
+		sort.Sort(&mapKeys{names, keys})
+		buf.WriteString(`{"kvlistValue":{"values":[`)
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"key":`)
+			valueFormatter.writeString(buf, names[i])
+			buf.WriteString(`,"value":`)
+			of.appendValue(buf, value.MapIndex(key).Interface(), depth+1)
+			buf.WriteByte('}')
+		}
+		buf.WriteString("]}}")
+	default:
+		v, err := toJSONValue(val)
+		if err != nil {
+			InternalLog.Error("Could not json.Marshal value: ", "formatter", "OTelFormatter", "err", err.Error())
+			of.writeStringValue(buf, fmt.Sprintf("%#v", val))
+			return
+		}
+		of.appendJSONValue(buf, v, depth)
+	}
+}
+
+// appendJSONValue writes a value decoded by encoding/json as an AnyValue.
+func (of *OTelFormatter) appendJSONValue(buf *bytes.Buffer, val interface{}, depth int) {
+	switch v := val.(type) {
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			of.writeIntValue(buf, string(v))
+		} else if f, err := v.Float64(); err == nil {
+			of.writeDoubleValue(buf, f)
+		} else {
+			of.writeStringValue(buf, string(v))
+		}
+	case []interface{}:
+		buf.WriteString(`{"arrayValue":{"values":[`)
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			of.appendJSONValue(buf, e, depth+1)
+		}
+		buf.WriteString("]}}")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteString(`{"kvlistValue":{"values":[`)
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"key":`)
+			valueFormatter.writeString(buf, key)
+			buf.WriteString(`,"value":`)
+			of.appendJSONValue(buf, v[key], depth+1)
+			buf.WriteByte('}')
+		}
+		buf.WriteString("]}}")
+	default:
+		of.appendValue(buf, v, depth+1)
+	}
+}
+
+func (of *OTelFormatter) writeStringValue(buf *bytes.Buffer, s string) {
+	buf.WriteString(`{"stringValue":`)
+	valueFormatter.writeString(buf, s)
+	buf.WriteByte('}')
+}
+
+// writeIntValue writes a 64-bit integer, which is a string in OTLP/JSON.
+func (of *OTelFormatter) writeIntValue(buf *bytes.Buffer, n string) {
+	buf.WriteString(`{"intValue":"`)
+	buf.WriteString(n)
+	buf.WriteString(`"}`)
+}
+
+// writeDoubleValue writes f, or "NaN", "Infinity" or "-Infinity".
+func (of *OTelFormatter) writeDoubleValue(buf *bytes.Buffer, f float64) {
+	buf.WriteString(`{"doubleValue":`)
+	switch {
+	case math.IsNaN(f):
+		buf.WriteString(`"NaN"`)
+	case math.IsInf(f, 1):
+		buf.WriteString(`"Infinity"`)
+	case math.IsInf(f, -1):
+		buf.WriteString(`"-Infinity"`)
+	default:
+		buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	buf.WriteByte('}')
+}
+
+// writeBytesValue writes p base64 encoded.
+func (of *OTelFormatter) writeBytesValue(buf *bytes.Buffer, p []byte) {
+	buf.WriteString(`{"bytesValue":"`)
+	buf.WriteString(base64.StdEncoding.EncodeToString(p))
+	buf.WriteString(`"}`)
+}
+
+type otlpRequest struct {
+	ResourceLogs []*otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  json.RawMessage  `json:"resource"`
+	ScopeLogs []*otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpScopeLogs struct {
+	Scope      json.RawMessage   `json:"scope"`
+	LogRecords []json.RawMessage `json:"logRecords"`
+}
+
+// OTLPBatchWriter merges the ExportLogsServiceRequests written by
+// OTelFormatter into one request per batch, grouping records by resource
+// and scope. A batch is written as a line when it has maxRecords records,
+// every interval and on Flush. It is safe for concurrent use.
+type OTLPBatchWriter struct {
+	writer     io.Writer
+	maxRecords int
+
+	mu      sync.Mutex
+	batch   otlpRequest
+	records int
+	closed  bool
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// NewOTLPBatchWriter creates an OTLPBatchWriter for writer. If interval is
+// 0, batches are only written when full or flushed.
+//
+// Example
+// writer := log.NewOTLPBatchWriter(file, 512, 5*time.Second)
+// defer writer.Close()
+// logger := log.NewLogger3(writer, "app", log.NewOTelFormatter("app"))
+func NewOTLPBatchWriter(writer io.Writer, maxRecords int, interval time.Duration) *OTLPBatchWriter {
+	if writer == nil {
+		panic("writer is nil")
+	}
+	if maxRecords < 1 {
+		maxRecords = 1
+	}
+	bw := &OTLPBatchWriter{
+		writer:     writer,
+		maxRecords: maxRecords,
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if interval > 0 {
+		go bw.tick(interval)
+	} else {
+		close(bw.done)
+	}
+	return bw
+}
+
+// tick flushes the batch every interval until Close.
+func (bw *OTLPBatchWriter) tick(interval time.Duration) {
+	defer close(bw.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			bw.Flush()
+		case <-bw.quit:
+			return
+		}
+	}
+}
+
+// Write adds the records of the ExportLogsServiceRequest p to the batch.
+func (bw *OTLPBatchWriter) Write(p []byte) (int, error) {
+	var req otlpRequest
+	if err := json.Unmarshal(p, &req); err != nil {
+		return 0, err
+	}
+
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	if bw.closed {
+		return 0, ErrWriterClosed
+	}
+	for _, rl := range req.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			scope := bw.scopeLogs(rl.Resource, sl.Scope)
+			scope.LogRecords = append(scope.LogRecords, sl.LogRecords...)
+			bw.records += len(sl.LogRecords)
+		}
+	}
+	if bw.records >= bw.maxRecords {
+		if err := bw.writeBatch(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// scopeLogs returns the ScopeLogs of resource and scope in the batch. mu
+// must be held.
+func (bw *OTLPBatchWriter) scopeLogs(resource, scope json.RawMessage) *otlpScopeLogs {
+	var rl *otlpResourceLogs
+	for _, r := range bw.batch.ResourceLogs {
+		if bytes.Equal(r.Resource, resource) {
+			rl = r
+			break
+		}
+	}
+	if rl == nil {
+		rl = &otlpResourceLogs{Resource: resource}
+		bw.batch.ResourceLogs = append(bw.batch.ResourceLogs, rl)
+	}
+	for _, sl := range rl.ScopeLogs {
+		if bytes.Equal(sl.Scope, scope) {
+			return sl
+		}
+	}
+	sl := &otlpScopeLogs{Scope: scope}
+	rl.ScopeLogs = append(rl.ScopeLogs, sl)
+	return sl
+}
+
+// writeBatch writes and resets the batch. mu must be held.
+func (bw *OTLPBatchWriter) writeBatch() error {
+	if bw.records == 0 {
+		return nil
+	}
+	b, err := json.Marshal(&bw.batch)
+	bw.batch = otlpRequest{}
+	bw.records = 0
+	if err != nil {
+		return err
+	}
+	_, err = bw.writer.Write(append(b, '\n'))
+	return err
+}
+
+// Flush writes the batch and flushes the underlying writer if it
+// implements Flusher.
+func (bw *OTLPBatchWriter) Flush() error {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	if err := bw.writeBatch(); err != nil {
+		return err
+	}
+	if f, ok := bw.writer.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close writes the batch and stops flushing every interval. Subsequent
+// writes return ErrWriterClosed. The underlying writer is not closed.
+func (bw *OTLPBatchWriter) Close() error {
+	bw.mu.Lock()
+	if bw.closed {
+		bw.mu.Unlock()
+		return nil
+	}
+	bw.closed = true
+	bw.mu.Unlock()
+
+	close(bw.quit)
+	<-bw.done
+	return bw.Flush()
+}
@@ -0,0 +1,82 @@
+package log
+
+import "path"
+
+// reconfigure reapplies the configuration to registered loggers. Formatters
+// are recreated if formats is true.
+func (lr *loggerRegistry) reconfigure(formats bool) {
+	lr.Lock()
+	defer lr.Unlock()
+	for name, logger := range lr.loggers {
+		if name == "__logxi" {
+			continue
+		}
+		if formats {
+			logger.reconfigure()
+		} else {
+			logger.level.set(getLogLevel(name))
+		}
+		logger.sampling.set(matchSampleRules(name))
+		logger.dedup.set(matchDedupRule(name))
+	}
+}
+
+// bindEnvSinks binds the sinks of LOGXI_SINKS to registered loggers created
+// from LOGXI_FORMAT.
+func (lr *loggerRegistry) bindEnvSinks() {
+	lr.Lock()
+	defer lr.Unlock()
+	for name, logger := range lr.loggers {
+		if name == "__logxi" || !logger.envFormat {
+			continue
+		}
+		logger.sinks.setEnv(bindEnvSinks(name))
+	}
+}
+
+// Loggers returns the registered loggers keyed by name. If more than one
+// logger was created with the same name, the most recent one is returned.
+func Loggers() map[string]Logger {
+	loggers.Lock()
+	defer loggers.Unlock()
+	result := make(map[string]Logger, len(loggers.loggers))
+	for name, logger := range loggers.loggers {
+		if name == "__logxi" {
+			continue
+		}
+		result[name] = logger
+	}
+	return result
+}
+
+// Lookup returns the registered logger named name. If more than one logger
+// was created with the same name, the most recent one is returned.
+func Lookup(name string) (Logger, bool) {
+	if name == "__logxi" {
+		return nil, false
+	}
+	loggers.Lock()
+	defer loggers.Unlock()
+	logger, ok := loggers.loggers[name]
+	if !ok {
+		return nil, false
+	}
+	return logger, true
+}
+
+// SetLevelByPattern sets the level of loggers matching a LOGXI pattern, eg
+// "models" or "server.*", as if it were added to LOGXI. The level applies
+// to existing and future loggers.
+func SetLevelByPattern(pattern string, level Level) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	pkgMutex.Lock()
+	defer pkgMutex.Unlock()
+	logxiNameLevelMap[pattern] = level
+	sortLevelPatterns()
+	logxiConfig.Levels = formatLogxiEnv()
+	loggers.reconfigure(false)
+	return nil
+}
@@ -0,0 +1,376 @@
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// rotateTimeFormat is the timestamp of rotated files. It sorts
+// lexicographically and has no colons for Windows.
+const rotateTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotateOptions configures when a RotatingWriter rotates and which rotated
+// files are kept.
+type RotateOptions struct {
+	// MaxSize rotates the file before it exceeds MaxSize bytes. 0 disables
+	// rotation by size.
+	MaxSize int64
+	// Interval rotates the file at multiples of Interval from local
+	// midnight, eg every hour on the hour or every day at midnight. 0
+	// disables rotation by time.
+	Interval time.Duration
+	// MaxAge removes rotated files older than MaxAge. 0 keeps all.
+	MaxAge time.Duration
+	// MaxCount keeps the MaxCount most recent rotated files. 0 keeps all.
+	MaxCount int
+	// Compress gzips rotated files in the background.
+	Compress bool
+}
+
+// RotatingWriter is a file writer which rotates by size and time. Rotated
+// files are named after the file with the time of rotation, eg
+// app-2006-01-02T15-04-05.000.log, optionally compressed and removed
+// according to retention. The file is reopened on SIGHUP, so it works with
+// logrotate. It is safe for concurrent use.
+type RotatingWriter struct {
+	filename string
+	options  RotateOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	rotateAt time.Time
+	closed   bool
+
+	// cleanMu serializes compression and retention
+	cleanMu sync.Mutex
+	cleanWg sync.WaitGroup
+
+	hup  chan os.Signal
+	quit chan struct{}
+	done chan struct{}
+
+	// now may be replaced in tests
+	now func() time.Time
+}
+
+// NewRotatingWriter opens filename for appending and rotates it according
+// to options.
+//
+// Example
+// writer, err := log.NewRotatingWriter("/var/log/app.log", log.RotateOptions{
+//     MaxSize:  100 << 20,
+//     Interval: 24 * time.Hour,
+//     MaxCount: 7,
+//     Compress: true,
+// })
+// logger := log.NewLogger(writer, "app")
+func NewRotatingWriter(filename string, options RotateOptions) (*RotatingWriter, error) {
+	rw := &RotatingWriter{
+		filename: filename,
+		options:  options,
+		hup:      make(chan os.Signal, 1),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		now:      time.Now,
+	}
+	if err := rw.open(); err != nil {
+		return nil, err
+	}
+
+	signal.Notify(rw.hup, syscall.SIGHUP)
+	go rw.watchSignals()
+	return rw, nil
+}
+
+// open opens the file and computes the next rotation time. mu must be held.
+func (rw *RotatingWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(rw.filename), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(rw.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rw.file = file
+	rw.size = fi.Size()
+	if rw.options.Interval > 0 {
+		rw.rotateAt = nextRotation(rw.now(), rw.options.Interval)
+	}
+	return nil
+}
+
+// nextRotation returns the first multiple of interval after t counted from
+// midnight in the location of t. Intervals of whole days end at midnight
+// even when daylight saving time changes.
+func nextRotation(t time.Time, interval time.Duration) time.Time {
+	const day = 24 * time.Hour
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	if interval%day == 0 {
+		return midnight.AddDate(0, 0, int(interval/day))
+	}
+	elapsed := t.Sub(midnight)
+	return midnight.Add((elapsed/interval + 1) * interval)
+}
+
+func (rw *RotatingWriter) watchSignals() {
+	defer close(rw.done)
+	for {
+		select {
+		case <-rw.hup:
+			if err := rw.Reopen(); err != nil && err != ErrWriterClosed {
+				InternalLog.Error("Could not reopen log file", "filename", rw.filename, "err", err)
+			}
+		case <-rw.quit:
+			return
+		}
+	}
+}
+
+// Write writes p to the file, rotating it first if p would exceed MaxSize
+// or the interval has elapsed.
+func (rw *RotatingWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.closed {
+		return 0, ErrWriterClosed
+	}
+
+	if rw.needsRotation(int64(len(p))) {
+		if err := rw.rotate(); err != nil {
+			InternalLog.Error("Could not rotate log file", "filename", rw.filename, "err", err)
+			if rw.file == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := rw.file.Write(p)
+	rw.size += int64(n)
+	return n, err
+}
+
+// needsRotation reports whether the file is rotated before writing n
+// bytes. mu must be held.
+func (rw *RotatingWriter) needsRotation(n int64) bool {
+	if rw.file == nil {
+		return true
+	}
+	if rw.options.MaxSize > 0 && rw.size > 0 && rw.size+n > rw.options.MaxSize {
+		return true
+	}
+	return rw.options.Interval > 0 && !rw.now().Before(rw.rotateAt)
+}
+
+// Rotate rotates the file now.
+func (rw *RotatingWriter) Rotate() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.closed {
+		return ErrWriterClosed
+	}
+	return rw.rotate()
+}
+
+// rotate renames the file and opens a new one. mu must be held.
+func (rw *RotatingWriter) rotate() error {
+	if rw.file != nil {
+		rw.file.Close()
+		rw.file = nil
+
+		rotated := rw.rotatedName(rw.now())
+		if err := os.Rename(rw.filename, rotated); err != nil && !os.IsNotExist(err) {
+			// keep appending to the file
+			if openErr := rw.open(); openErr != nil {
+				return openErr
+			}
+			return err
+		}
+
+		rw.cleanWg.Add(1)
+		go rw.clean(rotated)
+	}
+	return rw.open()
+}
+
+// rotatedName returns an unused name for the file rotated at t.
+func (rw *RotatingWriter) rotatedName(t time.Time) string {
+	ext := filepath.Ext(rw.filename)
+	prefix := strings.TrimSuffix(rw.filename, ext) + "-" + t.Format(rotateTimeFormat)
+	name := prefix + ext
+	for i := 1; ; i++ {
+		if !fileExists(name) && !fileExists(name+".gz") {
+			return name
+		}
+		name = prefix + "." + strconv.Itoa(i) + ext
+	}
+}
+
+func fileExists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+// Reopen closes and reopens the file, eg after logrotate moved it.
+func (rw *RotatingWriter) Reopen() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.closed {
+		return ErrWriterClosed
+	}
+	if rw.file != nil {
+		rw.file.Close()
+		rw.file = nil
+	}
+	return rw.open()
+}
+
+// clean compresses a rotated file and removes rotated files according to
+// retention.
+func (rw *RotatingWriter) clean(rotated string) {
+	defer rw.cleanWg.Done()
+	rw.cleanMu.Lock()
+	defer rw.cleanMu.Unlock()
+
+	if rw.options.Compress {
+		if err := compressFile(rotated); err != nil {
+			InternalLog.Error("Could not compress log file", "filename", rotated, "err", err)
+		}
+	}
+	if err := rw.removeExpired(); err != nil {
+		InternalLog.Error("Could not remove rotated log files", "filename", rw.filename, "err", err)
+	}
+}
+
+// compressFile gzips name to name.gz and removes name. Files removed by
+// retention are skipped.
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(name)
+}
+
+// rotatedFiles returns the rotated files from oldest to newest.
+func (rw *RotatingWriter) rotatedFiles() ([]string, error) {
+	ext := filepath.Ext(rw.filename)
+	prefix := filepath.Base(strings.TrimSuffix(rw.filename, ext)) + "-"
+	entries, err := ioutil.ReadDir(filepath.Dir(rw.filename))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimPrefix(name, prefix)
+		if len(stamp) < len(rotateTimeFormat) {
+			continue
+		}
+		if _, err := time.Parse(rotateTimeFormat, stamp[:len(rotateTimeFormat)]); err != nil {
+			continue
+		}
+		names = append(names, filepath.Join(filepath.Dir(rw.filename), name))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// removeExpired removes rotated files older than MaxAge or beyond
+// MaxCount.
+func (rw *RotatingWriter) removeExpired() error {
+	if rw.options.MaxAge <= 0 && rw.options.MaxCount <= 0 {
+		return nil
+	}
+	names, err := rw.rotatedFiles()
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for i, name := range names {
+		remove := rw.options.MaxCount > 0 && len(names)-i > rw.options.MaxCount
+		if !remove && rw.options.MaxAge > 0 {
+			fi, err := os.Stat(name)
+			remove = err == nil && rw.now().Sub(fi.ModTime()) > rw.options.MaxAge
+		}
+		if remove {
+			if err := os.Remove(name); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Flush commits the file to stable storage.
+func (rw *RotatingWriter) Flush() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.file == nil {
+		return nil
+	}
+	return rw.file.Sync()
+}
+
+// Close closes the file and waits until rotated files are compressed.
+// Subsequent writes return ErrWriterClosed.
+func (rw *RotatingWriter) Close() error {
+	rw.mu.Lock()
+	if rw.closed {
+		rw.mu.Unlock()
+		return nil
+	}
+	rw.closed = true
+	var err error
+	if rw.file != nil {
+		err = rw.file.Close()
+		rw.file = nil
+	}
+	rw.mu.Unlock()
+
+	signal.Stop(rw.hup)
+	close(rw.quit)
+	<-rw.done
+	rw.cleanWg.Wait()
+	return err
+}
@@ -0,0 +1,254 @@
+package log
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SuppressedKey is the key of the number of entries suppressed by sampling
+// since the previous entry with the same message was logged.
+var SuppressedKey = "_suppressed"
+
+// defaultSampleInterval is the interval of a LOGXI_SAMPLE rule without one
+const defaultSampleInterval = time.Second
+
+// maxSampleCounters bounds the number of messages tracked by a rule
+const maxSampleCounters = 10000
+
+// sampleRule logs the first entries of a message per interval and then
+// every nth entry.
+type sampleRule struct {
+	pattern string
+	// level is the level sampled if hasLevel, otherwise all levels are
+	level    Level
+	hasLevel bool
+	first    int
+	every    int
+	interval time.Duration
+	literals int
+	wilds    int
+
+	mu       sync.Mutex
+	counters map[string]*sampleCounter
+}
+
+type sampleCounter struct {
+	start      time.Time
+	n          int
+	suppressed int
+}
+
+// logxiSampleRules are the rules of LOGXI_SAMPLE ordered from most to
+// least specific
+var logxiSampleRules []*sampleRule
+
+// logxiSampleEnv is the LOGXI_SAMPLE value of logxiSampleRules
+var logxiSampleEnv string
+
+// sampleMutex guards logxiSampleRules and logxiSampleEnv
+var sampleMutex sync.Mutex
+
+// ProcessLogxiSampleEnv parses LOGXI_SAMPLE. Each rule is a LOGXI name
+// pattern, optionally followed by a level, and the number of entries of a
+// message to log per interval before logging every nth entry.
+//
+//     LOGXI_SAMPLE=*=100/10,server.http:DBG=10/100/1m
+//
+// logs the first 100 entries of a message per second then every 10th. For
+// DBG entries of server.http and its descendants, the first 10 entries per
+// minute then every 100th. If every is omitted or 0, the rest are dropped.
+// ERR and more severe entries are never sampled. "off" disables sampling.
+//
+// The rules are kept if env is unchanged so counts continue.
+func ProcessLogxiSampleEnv(env string) {
+	var rules []*sampleRule
+	if env == "off" {
+		env = ""
+	}
+	sampleMutex.Lock()
+	unchanged := env == logxiSampleEnv
+	sampleMutex.Unlock()
+	if unchanged {
+		return
+	}
+	for key, value := range parseKVList(env, ",") {
+		rule, err := parseSampleRule(key, value)
+		if err != nil {
+			InternalLog.Error("Invalid rule in LOGXI_SAMPLE environment variable", "key", key, "value", value, "err", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.literals != b.literals {
+			return a.literals > b.literals
+		}
+		if a.wilds != b.wilds {
+			return a.wilds < b.wilds
+		}
+		if a.hasLevel != b.hasLevel {
+			return a.hasLevel
+		}
+		if a.pattern != b.pattern {
+			return a.pattern < b.pattern
+		}
+		return a.level < b.level
+	})
+	sampleMutex.Lock()
+	logxiSampleRules = rules
+	logxiSampleEnv = env
+	sampleMutex.Unlock()
+}
+
+func parseSampleRule(key, value string) (*sampleRule, error) {
+	rule := &sampleRule{
+		pattern:  key,
+		interval: defaultSampleInterval,
+		counters: map[string]*sampleCounter{},
+	}
+	if idx := strings.LastIndex(key, ":"); idx > -1 {
+		level, err := ParseLevel(key[idx+1:])
+		if err != nil {
+			return nil, err
+		}
+		rule.pattern = key[:idx]
+		rule.level = level
+		rule.hasLevel = true
+	}
+	if _, err := path.Match(rule.pattern, ""); err != nil {
+		return nil, err
+	}
+	rule.literals, rule.wilds = patternSpecificity(rule.pattern)
+
+	parts := strings.Split(value, "/")
+	if value == "" || len(parts) > 3 {
+		return nil, fmt.Errorf("expected first/every/interval")
+	}
+	var err error
+	if rule.first, err = strconv.Atoi(parts[0]); err != nil || rule.first < 0 {
+		return nil, fmt.Errorf("invalid first %q", parts[0])
+	}
+	if len(parts) > 1 {
+		if rule.every, err = strconv.Atoi(parts[1]); err != nil || rule.every < 0 {
+			return nil, fmt.Errorf("invalid every %q", parts[1])
+		}
+	}
+	if len(parts) > 2 {
+		if rule.interval, err = time.ParseDuration(parts[2]); err != nil || rule.interval <= 0 {
+			return nil, fmt.Errorf("invalid interval %q", parts[2])
+		}
+	}
+	return rule, nil
+}
+
+// sample counts an entry of message key. It returns whether the entry is
+// logged and, if so, the number of entries suppressed since the previous
+// one was logged.
+func (r *sampleRule) sample(key string, now time.Time) (bool, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := r.counters[key]
+	if c == nil {
+		if len(r.counters) >= maxSampleCounters {
+			r.purge(now)
+		}
+		if len(r.counters) >= maxSampleCounters {
+			r.evict()
+		}
+		c = &sampleCounter{start: now}
+		r.counters[key] = c
+	}
+	if now.Sub(c.start) >= r.interval {
+		c.start = now
+		c.n = 0
+	}
+
+	c.n++
+	if c.n <= r.first || (r.every > 0 && (c.n-r.first)%r.every == 0) {
+		suppressed := c.suppressed
+		c.suppressed = 0
+		return true, suppressed
+	}
+	c.suppressed++
+	return false, 0
+}
+
+// purge removes the counters of expired intervals.
+func (r *sampleRule) purge(now time.Time) {
+	for key, c := range r.counters {
+		if now.Sub(c.start) >= r.interval {
+			delete(r.counters, key)
+		}
+	}
+}
+
+// evict removes arbitrary counters until a quarter of maxSampleCounters is
+// free so high-cardinality messages do not purge on every entry.
+func (r *sampleRule) evict() {
+	for key := range r.counters {
+		if len(r.counters) < maxSampleCounters*3/4 {
+			return
+		}
+		delete(r.counters, key)
+	}
+}
+
+// samplerSet are the LOGXI_SAMPLE rules matching a logger. It is shared
+// with child loggers created by With.
+type samplerSet struct {
+	sync.RWMutex
+	rules []*sampleRule
+}
+
+// matchSampleRules returns the rules matching the logger name.
+func matchSampleRules(name string) []*sampleRule {
+	sampleMutex.Lock()
+	defer sampleMutex.Unlock()
+	var rules []*sampleRule
+	for _, rule := range logxiSampleRules {
+		if matchName(rule.pattern, name) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (ss *samplerSet) set(rules []*sampleRule) {
+	ss.Lock()
+	ss.rules = rules
+	ss.Unlock()
+}
+
+// sample applies the most specific rule for the level of entry. It returns
+// false if the entry is suppressed. The number of entries suppressed since
+// the previous entry of the message is added as SuppressedKey.
+func (ss *samplerSet) sample(entry *Entry) bool {
+	// never sample errors
+	if entry.Level <= LevelError {
+		return true
+	}
+
+	ss.RLock()
+	rules := ss.rules
+	ss.RUnlock()
+	for _, rule := range rules {
+		if rule.hasLevel && rule.level != entry.Level {
+			continue
+		}
+		key := strconv.Itoa(int(entry.Level)) + "\x00" + entry.Name + "\x00" + entry.Message
+		keep, suppressed := rule.sample(key, entry.Time)
+		if suppressed > 0 {
+			entry.Fields = append(entry.Fields, Field{Key: SuppressedKey, Value: suppressed})
+		}
+		return keep
+	}
+	return true
+}
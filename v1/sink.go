@@ -0,0 +1,426 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// Sink is a destination of entries with its own formatter and minimum
+// level. A logger writes each entry to its writer and to every sink which
+// accepts the entry.
+type Sink struct {
+	// Writer must be safe for concurrent use. LevelWriters are passed the
+	// level of entries.
+	Writer io.Writer
+	// Formatter formats entries for the sink. It is copied for the name of
+	// each logger. If nil, a formatter is created from LOGXI_FORMAT.
+	Formatter Formatter
+	// Level is the least severe level written, eg LevelInfo writes info
+	// and more severe entries. Entries are first filtered by the level of
+	// the logger.
+	Level Level
+	// Filter, if set, returns false for entries which are not written.
+	Filter func(entry *Entry) bool
+}
+
+// NewSink creates a sink which writes entries at level or more severe to
+// writer.
+//
+// Example
+// file := log.NewSink(f, log.NewJSONFormatter("app"), log.LevelInfo)
+// remote := log.NewSink(conn, nil, log.LevelError)
+// logger := log.NewSinkLogger("app", file, remote)
+func NewSink(writer io.Writer, formatter Formatter, level Level) *Sink {
+	return &Sink{Writer: writer, Formatter: formatter, Level: level}
+}
+
+// boundSink is a sink with its formatter for a logger.
+type boundSink struct {
+	sink      *Sink
+	formatter Formatter
+}
+
+func bindSink(sink *Sink, name string) *boundSink {
+	var formatter Formatter
+	if sink.Formatter != nil {
+		formatter = withName(sink.Formatter, name)
+	} else {
+		var err error
+		formatter, err = createFormatter(name, FormatEnv)
+		if err != nil {
+			InternalLog.Error("Could not create formatter", "name", name, "err", err)
+			return nil
+		}
+	}
+	return &boundSink{sink: sink, formatter: formatter}
+}
+
+// write writes entry if it is accepted by the sink.
+func (bs *boundSink) write(entry *Entry) {
+	if entry.Level > bs.sink.Level {
+		return
+	}
+	if bs.sink.Filter != nil && !bs.sink.Filter(entry) {
+		return
+	}
+	writeEntry(bs.formatter, bs.sink.Writer, entry)
+}
+
+// sinkSet are the sinks of a logger. It is shared with child loggers
+// created by With.
+type sinkSet struct {
+	sync.RWMutex
+	// sinks are added with AddSink
+	sinks []*boundSink
+	// env are the sinks of LOGXI_SINKS
+	env []*boundSink
+}
+
+// add appends sink. The slices are copied as they are read without a lock
+// by all.
+func (ss *sinkSet) add(bs *boundSink) {
+	ss.Lock()
+	sinks := make([]*boundSink, 0, len(ss.sinks)+1)
+	sinks = append(sinks, ss.sinks...)
+	ss.sinks = append(sinks, bs)
+	ss.Unlock()
+}
+
+func (ss *sinkSet) setEnv(sinks []*boundSink) {
+	ss.Lock()
+	ss.env = sinks
+	ss.Unlock()
+}
+
+// named returns the sinks added in code bound for a child logger named
+// name.
+func (ss *sinkSet) named(name string) []*boundSink {
+	ss.RLock()
+	defer ss.RUnlock()
+	var sinks []*boundSink
+	for _, bs := range ss.sinks {
+		if bound := bindSink(bs.sink, name); bound != nil {
+			sinks = append(sinks, bound)
+		}
+	}
+	return sinks
+}
+
+// all returns the sinks added in code followed by those of LOGXI_SINKS.
+func (ss *sinkSet) all() []*boundSink {
+	ss.RLock()
+	defer ss.RUnlock()
+	if len(ss.env) == 0 {
+		return ss.sinks
+	}
+	if len(ss.sinks) == 0 {
+		return ss.env
+	}
+	all := make([]*boundSink, 0, len(ss.sinks)+len(ss.env))
+	all = append(all, ss.sinks...)
+	return append(all, ss.env...)
+}
+
+// writers returns the writers of the sinks for Flush.
+func (ss *sinkSet) writers() []io.Writer {
+	var writers []io.Writer
+	for _, bs := range ss.all() {
+		writers = append(writers, bs.sink.Writer)
+	}
+	return writers
+}
+
+// NewSinkLogger creates a logger which only writes to sinks.
+//
+// Example
+// logger := log.NewSinkLogger("app",
+//     log.NewSink(log.NewConcurrentWriter(os.Stdout), log.NewHappyDevFormatter("app"), log.LevelDebug),
+//     log.NewSink(file, log.NewJSONFormatter("app"), log.LevelInfo),
+// )
+func NewSinkLogger(name string, sinks ...*Sink) Logger {
+	log := newLogger(nil, name, nil)
+	for _, sink := range sinks {
+		log.AddSink(sink)
+	}
+	return log
+}
+
+// AddSink adds a sink to this logger and its children created by With.
+func (l *DefaultLogger) AddSink(sink *Sink) {
+	if sink == nil || sink.Writer == nil {
+		panic("sink has no writer")
+	}
+	if bs := bindSink(sink, l.name); bs != nil {
+		l.sinks.add(bs)
+	}
+}
+
+// writeSinks writes entry to the sinks. hasContext is true if the fields of
+// entry include the pairs bound with With.
+func (l *DefaultLogger) writeSinks(entry *Entry, hasContext bool) {
+	if len(l.sinks.all()) == 0 {
+		return
+	}
+	// writers of LOGXI_SINKS are not closed while entries are written
+	sinkWritesMutex.RLock()
+	defer sinkWritesMutex.RUnlock()
+	sinks := l.sinks.all()
+	// the formatters of sinks do not bind pairs
+	if !hasContext && len(l.context) > 0 {
+		e := *entry
+		e.Fields = append(argsToFields(l.context), entry.Fields...)
+		if e.Stack == "" && hasError(e.Fields) {
+			e.Stack = string(debug.Stack())
+		}
+		entry = &e
+	}
+	for _, bs := range sinks {
+		bs.write(entry)
+	}
+}
+
+// formattersNeedCaller reports whether the formatter of the writer or of a
+// sink needs the caller of every entry.
+func (l *DefaultLogger) formattersNeedCaller() bool {
+	if l.writer != nil && formatterNeedsCaller(l.formatters().formatter) {
+		return true
+	}
+	for _, bs := range l.sinks.all() {
+		if formatterNeedsCaller(bs.formatter) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeEntry formats entry with formatter and writes it to writer, passing
+// the level to LevelWriters.
+func writeEntry(formatter Formatter, writer io.Writer, entry *Entry) {
+	if lw, ok := asLevelWriter(writer); ok {
+		buf := pool.Get()
+		defer pool.Put(buf)
+		formatEntry(formatter, buf, entry)
+		lw.WriteLevel(entry.Level, buf.Bytes())
+		return
+	}
+	formatEntry(formatter, writer, entry)
+}
+
+// CreateSinkWriterFunc opens the writer of a LOGXI_SINKS target. The
+// writer must be safe for concurrent use. If it implements io.Closer, it
+// is closed when the target is removed from LOGXI_SINKS.
+type CreateSinkWriterFunc func(target string) (io.Writer, error)
+
+var sinkWriterCreators = map[string]CreateSinkWriterFunc{}
+
+// RegisterSinkFactory registers a function which opens LOGXI_SINKS targets
+// of scheme, eg "tcp" for "tcp://127.0.0.1:5000".
+func RegisterSinkFactory(scheme string, fn CreateSinkWriterFunc) {
+	if scheme == "" {
+		panic("scheme is empty string")
+	}
+	if fn == nil {
+		panic("creator is nil")
+	}
+	sinkWriterCreators[scheme] = fn
+}
+
+// envSink is a sink of LOGXI_SINKS.
+type envSink struct {
+	sink    *Sink
+	format  string
+	pattern string
+}
+
+// logxiSinks are the sinks of LOGXI_SINKS
+var logxiSinks []*envSink
+
+// sinkWriters are the open writers of LOGXI_SINKS keyed by target. They are
+// reused when the configuration is reprocessed.
+var sinkWriters = map[string]io.Writer{}
+
+// sinkWritesMutex is read locked while entries are written to sinks and
+// locked to close the writers removed from LOGXI_SINKS
+var sinkWritesMutex sync.RWMutex
+
+// ProcessLogxiSinksEnv parses LOGXI_SINKS. Each sink is a target, then
+// optionally a format, level and LOGXI name pattern separated by "/".
+//
+//     LOGXI_SINKS=/var/log/app.log=JSON/INF,tcp://logs:5000=JSON/ERR/server.*
+//
+// writes INF and more severe entries of every logger created from
+// LOGXI_FORMAT as JSON to /var/log/app.log, and ERR and more severe
+// entries of server and its descendants to a TCP connection. Targets are
+// "stdout", "stderr", a file path or a URL whose scheme is registered with
+// RegisterSinkFactory. "tcp", "udp", "unix" and "file" are built in. The
+// format defaults to LOGXI_FORMAT and the level to all. "off" removes the
+// sinks. A target containing "=" is followed by "=" even without a format,
+// eg "file:///tmp/a=b.log=".
+//
+// Registered loggers are rebound to the new sinks before the writers which
+// were removed are closed.
+func ProcessLogxiSinksEnv(env string) {
+	var sinks []*envSink
+	used := map[string]bool{}
+	if env == "off" {
+		env = ""
+	}
+	for _, spec := range strings.Split(env, ",") {
+		if spec == "" {
+			continue
+		}
+		target, value := splitEnvSink(spec)
+		sink, err := parseEnvSink(target, value)
+		if err != nil {
+			InternalLog.Error("Invalid sink in LOGXI_SINKS environment variable", "target", target, "value", value, "err", err)
+			continue
+		}
+		used[target] = true
+		sinks = append(sinks, sink)
+	}
+
+	var removed []io.Writer
+	for target, writer := range sinkWriters {
+		if used[target] {
+			continue
+		}
+		delete(sinkWriters, target)
+		removed = append(removed, writer)
+	}
+	logxiSinks = sinks
+	loggers.bindEnvSinks()
+
+	if len(removed) == 0 {
+		return
+	}
+	// wait for entries written with the previous sinks
+	sinkWritesMutex.Lock()
+	defer sinkWritesMutex.Unlock()
+	for _, writer := range removed {
+		if c, ok := writer.(io.Closer); ok && writer != os.Stdout && writer != os.Stderr {
+			c.Close()
+		}
+	}
+}
+
+// splitEnvSink splits a sink of LOGXI_SINKS into its target and value at
+// the first "=" followed by a valid format, level and pattern. Otherwise it
+// splits at the last "=" so an invalid value is reported.
+func splitEnvSink(spec string) (target string, value string) {
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '=' {
+			continue
+		}
+		parts := strings.Split(spec[i+1:], "/")
+		if len(parts) > 3 || strings.Contains(spec[i+1:], "=") {
+			continue
+		}
+		if parts[0] == "" || formatterCreators[parts[0]] != nil {
+			return spec[:i], spec[i+1:]
+		}
+	}
+	if idx := strings.LastIndex(spec, "="); idx > -1 {
+		return spec[:idx], spec[idx+1:]
+	}
+	return spec, ""
+}
+
+func parseEnvSink(target, value string) (*envSink, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("expected format/level/pattern")
+	}
+	sink := &envSink{
+		sink:    &Sink{Level: LevelAll},
+		format:  parts[0],
+		pattern: "*",
+	}
+	if sink.format != "" && formatterCreators[sink.format] == nil {
+		return nil, fmt.Errorf("unknown format %q", sink.format)
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		level, err := ParseLevel(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		sink.sink.Level = level
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		if _, err := path.Match(parts[2], ""); err != nil {
+			return nil, err
+		}
+		sink.pattern = parts[2]
+	}
+
+	writer := sinkWriters[target]
+	if writer == nil {
+		var err error
+		if writer, err = openSinkWriter(target); err != nil {
+			return nil, err
+		}
+		sinkWriters[target] = writer
+	}
+	sink.sink.Writer = writer
+	return sink, nil
+}
+
+// openSinkWriter opens the writer of a LOGXI_SINKS target.
+func openSinkWriter(target string) (io.Writer, error) {
+	switch target {
+	case "":
+		return nil, fmt.Errorf("target is empty")
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	scheme := "file"
+	if idx := strings.Index(target, "://"); idx > -1 {
+		scheme = target[:idx]
+	}
+	fn := sinkWriterCreators[scheme]
+	if fn == nil {
+		return nil, fmt.Errorf("unknown scheme %q", scheme)
+	}
+	return fn(target)
+}
+
+// bindEnvSinks returns the sinks of LOGXI_SINKS matching the logger name.
+func bindEnvSinks(name string) []*boundSink {
+	var sinks []*boundSink
+	for _, es := range logxiSinks {
+		if !matchName(es.pattern, name) {
+			continue
+		}
+		kind := es.format
+		if kind == "" {
+			kind = FormatEnv
+		}
+		formatter, err := createFormatter(name, kind)
+		if err != nil {
+			InternalLog.Error("Could not create formatter", "name", name, "err", err)
+			continue
+		}
+		sinks = append(sinks, &boundSink{sink: es.sink, formatter: formatter})
+	}
+	return sinks
+}
+
+// fileSinkFactory opens a file for appending. The target is a path or a
+// file:// URL.
+func fileSinkFactory(target string) (io.Writer, error) {
+	name := strings.TrimPrefix(target, "file://")
+	return os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+// netSinkFactory dials the address of a tcp://, udp:// or unix:// target.
+func netSinkFactory(target string) (io.Writer, error) {
+	idx := strings.Index(target, "://")
+	return net.Dial(target[:idx], target[idx+3:])
+}
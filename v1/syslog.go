@@ -0,0 +1,381 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Facility is a syslog facility.
+type Facility int
+
+// Syslog facilities of RFC 5424.
+const (
+	FacilityKern     Facility = 0
+	FacilityUser     Facility = 1
+	FacilityMail     Facility = 2
+	FacilityDaemon   Facility = 3
+	FacilityAuth     Facility = 4
+	FacilitySyslog   Facility = 5
+	FacilityLpr      Facility = 6
+	FacilityNews     Facility = 7
+	FacilityUucp     Facility = 8
+	FacilityCron     Facility = 9
+	FacilityAuthpriv Facility = 10
+	FacilityFtp      Facility = 11
+	FacilityLocal0   Facility = 16
+	FacilityLocal1   Facility = 17
+	FacilityLocal2   Facility = 18
+	FacilityLocal3   Facility = 19
+	FacilityLocal4   Facility = 20
+	FacilityLocal5   Facility = 21
+	FacilityLocal6   Facility = 22
+	FacilityLocal7   Facility = 23
+)
+
+var facilityNames = map[string]Facility{
+	"kern":     FacilityKern,
+	"user":     FacilityUser,
+	"mail":     FacilityMail,
+	"daemon":   FacilityDaemon,
+	"auth":     FacilityAuth,
+	"syslog":   FacilitySyslog,
+	"lpr":      FacilityLpr,
+	"news":     FacilityNews,
+	"uucp":     FacilityUucp,
+	"cron":     FacilityCron,
+	"authpriv": FacilityAuthpriv,
+	"ftp":      FacilityFtp,
+	"local0":   FacilityLocal0,
+	"local1":   FacilityLocal1,
+	"local2":   FacilityLocal2,
+	"local3":   FacilityLocal3,
+	"local4":   FacilityLocal4,
+	"local5":   FacilityLocal5,
+	"local6":   FacilityLocal6,
+	"local7":   FacilityLocal7,
+}
+
+// ParseFacility parses a facility name like "daemon" or "local0".
+func ParseFacility(s string) (Facility, error) {
+	if f, ok := facilityNames[strings.ToLower(s)]; ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("unknown facility %q", s)
+}
+
+// syslogSDID is the SD-ID of fields. 32473 is the enterprise number
+// reserved for documentation by RFC 5612.
+const syslogSDID = "logxi@32473"
+
+// syslogSeverity maps a level to a syslog severity. LevelFatal is critical.
+// Other levels more severe than LevelAlert are custom levels which are
+// always logged and are notices. LevelTrace is debug.
+func syslogSeverity(level Level) int {
+	switch {
+	case level == LevelEmergency:
+		return 0
+	case level == LevelFatal:
+		return int(LevelCritical)
+	case level < LevelAlert:
+		return int(LevelNotice)
+	case level > LevelDebug:
+		return int(LevelDebug)
+	}
+	return int(level)
+}
+
+// SyslogFormatter formats entries as RFC 5424 syslog messages with fields as
+// STRUCTURED-DATA, or as RFC 3164 (BSD) messages with fields appended to the
+// message. The APP-NAME is the logger name and the PROCID is the process
+// id.
+type SyslogFormatter struct {
+	name string
+	// Facility defaults to the facility of LOGXI_FORMAT or FacilityUser.
+	Facility Facility
+	// Hostname defaults to os.Hostname.
+	Hostname string
+	// SDID is the SD-ID of the STRUCTURED-DATA element of fields.
+	SDID string
+	// RFC3164 formats BSD syslog messages.
+	RFC3164 bool
+}
+
+// NewSyslogFormatter creates a new RFC 5424 SyslogFormatter.
+//
+// Example
+// writer, err := log.NewSyslogWriter("tcp", "logs:601")
+// formatter := log.NewSyslogFormatter("app")
+// formatter.Facility = log.FacilityLocal0
+// logger := log.NewLogger3(writer, "app", formatter)
+func NewSyslogFormatter(name string) *SyslogFormatter {
+	hostname, _ := os.Hostname()
+	return &SyslogFormatter{
+		name:     name,
+		Facility: settings().facility,
+		Hostname: hostname,
+		SDID:     syslogSDID,
+	}
+}
+
+// WithName returns a copy of the formatter for name.
+func (sf *SyslogFormatter) WithName(name string) Formatter {
+	c := *sf
+	c.name = name
+	return &c
+}
+
+// Format formats a log entry as a syslog message.
+func (sf *SyslogFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	sf.FormatEntry(writer, NewEntry(level, sf.name, msg, args))
+}
+
+// FormatEntry formats entry as a syslog message terminated by a newline.
+func (sf *SyslogFormatter) FormatEntry(writer io.Writer, entry *Entry) {
+	buf := pool.Get()
+	defer pool.Put(buf)
+
+	buf.WriteByte('<')
+	buf.WriteString(strconv.Itoa(int(sf.Facility)*8 + syslogSeverity(entry.Level)))
+	buf.WriteByte('>')
+	if sf.RFC3164 {
+		sf.writeRFC3164(buf, entry)
+	} else {
+		sf.writeRFC5424(buf, entry)
+	}
+	buf.WriteByte('\n')
+	buf.WriteTo(writer)
+}
+
+// writeRFC5424 writes VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP
+// PROCID SP MSGID SP STRUCTURED-DATA [SP MSG].
+func (sf *SyslogFormatter) writeRFC5424(buf *bytes.Buffer, entry *Entry) {
+	buf.WriteString("1 ")
+	buf.WriteString(entry.Time.Format("2006-01-02T15:04:05.000000Z07:00"))
+	buf.WriteByte(' ')
+	buf.WriteString(syslogHeaderField(sf.Hostname, 255))
+	buf.WriteByte(' ')
+	buf.WriteString(syslogHeaderField(sf.name, 48))
+	buf.WriteByte(' ')
+	buf.WriteString(pidStr)
+	buf.WriteString(" - ")
+
+	if len(entry.Fields) == 0 {
+		buf.WriteByte('-')
+	} else {
+		buf.WriteByte('[')
+		buf.WriteString(sf.SDID)
+		for _, field := range entry.Fields {
+			buf.WriteByte(' ')
+			buf.WriteString(syslogParamName(field.Key))
+			buf.WriteString(`="`)
+			syslogEscape(buf, valueString(field.Value))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte(']')
+	}
+
+	if entry.Message != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(entry.Message)
+	}
+}
+
+// writeRFC3164 writes TIMESTAMP SP HOSTNAME SP TAG[PID]: MSG.
+func (sf *SyslogFormatter) writeRFC3164(buf *bytes.Buffer, entry *Entry) {
+	buf.WriteString(entry.Time.Format("Jan _2 15:04:05"))
+	buf.WriteByte(' ')
+	buf.WriteString(syslogHeaderField(sf.Hostname, 255))
+	buf.WriteByte(' ')
+	buf.WriteString(syslogHeaderField(sf.name, 32))
+	buf.WriteByte('[')
+	buf.WriteString(pidStr)
+	buf.WriteString("]: ")
+	buf.WriteString(entry.Message)
+	for _, field := range entry.Fields {
+		buf.WriteByte(' ')
+		buf.WriteString(field.Key)
+		buf.WriteByte('=')
+		value := valueString(field.Value)
+		if value == "" || strings.ContainsAny(value, " \"=\n") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+}
+
+// syslogHeaderField returns s with characters other than printable US-ASCII
+// replaced and truncated to max, or NILVALUE if empty.
+func syslogHeaderField(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// syslogParamName returns key as a PARAM-NAME, which is 1 to 32 printable
+// US-ASCII characters except '=', ' ', ']' and '"'.
+func syslogParamName(key string) string {
+	if key == "" {
+		return "_"
+	}
+	if len(key) > 32 {
+		key = key[:32]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// syslogEscape writes a PARAM-VALUE escaping '"', '\' and ']'.
+func syslogEscape(buf *bytes.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', ']':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
+
+// syslogPaths are the local syslog sockets tried by NewSyslogWriter
+var syslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogWriter sends messages to a syslog server. Each write is a message.
+// Messages over TCP and unix stream sockets are framed with octet counting
+// (RFC 6587). The connection is reestablished when a write fails. It is
+// safe for concurrent use.
+type SyslogWriter struct {
+	network string
+	addr    string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+// NewSyslogWriter connects to the syslog server at addr on network, which
+// is "unixgram", "unix", "udp" or "tcp". If network and addr are empty, it
+// connects to the local syslog daemon.
+//
+// Example
+// writer, err := log.NewSyslogWriter("udp", "127.0.0.1:514")
+// logger := log.NewLogger3(writer, "app", log.NewSyslogFormatter("app"))
+func NewSyslogWriter(network, addr string) (*SyslogWriter, error) {
+	sw := &SyslogWriter{network: network, addr: addr}
+	if err := sw.connect(); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// connect dials the server. mu must be held.
+func (sw *SyslogWriter) connect() error {
+	if sw.network != "" || sw.addr != "" {
+		conn, err := net.Dial(sw.network, sw.addr)
+		if err != nil {
+			return err
+		}
+		sw.conn = conn
+		return nil
+	}
+
+	for _, path := range syslogPaths {
+		conn, err := net.Dial("unixgram", path)
+		if err == nil {
+			sw.conn = conn
+			return nil
+		}
+	}
+	return fmt.Errorf("could not connect to local syslog")
+}
+
+// isStream reports whether messages are framed with octet counting.
+func (sw *SyslogWriter) isStream() bool {
+	switch sw.network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return true
+	}
+	return false
+}
+
+// Write sends p as a message without its trailing newline. If the write
+// fails, it reconnects and sends p again.
+func (sw *SyslogWriter) Write(p []byte) (int, error) {
+	msg := bytes.TrimSuffix(p, []byte("\n"))
+	if sw.isStream() {
+		framed := make([]byte, 0, len(msg)+8)
+		framed = strconv.AppendInt(framed, int64(len(msg)), 10)
+		framed = append(framed, ' ')
+		msg = append(framed, msg...)
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.closed {
+		return 0, ErrWriterClosed
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if sw.conn == nil {
+			if err = sw.connect(); err != nil {
+				continue
+			}
+		}
+		if _, err = sw.conn.Write(msg); err == nil {
+			return len(p), nil
+		}
+		sw.conn.Close()
+		sw.conn = nil
+	}
+	return 0, err
+}
+
+// Close closes the connection. Subsequent writes return ErrWriterClosed.
+func (sw *SyslogWriter) Close() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.closed = true
+	if sw.conn == nil {
+		return nil
+	}
+	err := sw.conn.Close()
+	sw.conn = nil
+	return err
+}
+
+// syslogSinkFactory opens a SyslogWriter for LOGXI_SINKS targets like
+// "syslog://" for the local daemon, "syslog://host:514" for UDP and
+// "syslog+tcp://host:601" for TCP.
+func syslogSinkFactory(target string) (io.Writer, error) {
+	idx := strings.Index(target, "://")
+	scheme, addr := target[:idx], target[idx+3:]
+	network := strings.TrimPrefix(strings.TrimPrefix(scheme, "syslog"), "+")
+	if network == "" && addr != "" {
+		network = "udp"
+	}
+	writer, err := NewSyslogWriter(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
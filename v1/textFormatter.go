@@ -4,104 +4,122 @@ import (
 	"fmt"
 	"io"
 	"runtime/debug"
-	"time"
 )
 
 // Formatter records log entries.
 type Formatter interface {
-	Format(writer io.Writer, level int, msg string, args []interface{})
+	Format(writer io.Writer, level Level, msg string, args []interface{})
 }
 
 // TextFormatter is the default recorder used if one is unspecified when
 // creating a new Logger.
 type TextFormatter struct {
 	name         string
-	itoaLevelMap map[int]string
+	itoaLevelMap map[Level]string
 	timeLabel    string
+	// context is the pre-encoded key-value pairs bound with Logger.With
+	context string
 }
 
 // NewTextFormatter returns a new instance of TextFormatter. SetName
 // must be called befored using it.
 func NewTextFormatter(name string) *TextFormatter {
-	timeLabel := KeyMap.Time + AssignmentChar
-	levelLabel := Separator + KeyMap.Level + AssignmentChar
-	messageLabel := Separator + KeyMap.Message + AssignmentChar
-	nameLabel := Separator + KeyMap.Name + AssignmentChar
-	pidLabel := Separator + KeyMap.PID + AssignmentChar
+	tf := &TextFormatter{name: name, timeLabel: KeyMap.Time + AssignmentChar}
+	tf.itoaLevelMap = map[Level]string{}
+	levelsMutex.RLock()
+	for level, label := range LevelMap {
+		tf.itoaLevelMap[level] = tf.buildKV(label)
+	}
+	levelsMutex.RUnlock()
+	return tf
+}
 
-	var buildKV = func(level string) string {
-		buf := pool.Get()
-		defer pool.Put(buf)
+// buildKV pre-builds the key-value pairs which are the same for every
+// entry of a level.
+func (tf *TextFormatter) buildKV(level string) string {
+	buf := pool.Get()
+	defer pool.Put(buf)
 
-		buf.WriteString(pidLabel)
-		buf.WriteString(pidStr)
+	buf.WriteString(Separator + KeyMap.PID + AssignmentChar)
+	buf.WriteString(pidStr)
 
-		//buf.WriteString(Separator)
-		buf.WriteString(nameLabel)
-		buf.WriteString(name)
+	buf.WriteString(Separator + KeyMap.Name + AssignmentChar)
+	buf.WriteString(tf.name)
 
-		//buf.WriteString(Separator)
-		buf.WriteString(levelLabel)
-		buf.WriteString(level)
+	buf.WriteString(Separator + KeyMap.Level + AssignmentChar)
+	buf.WriteString(level)
 
-		//buf.WriteString(Separator)
-		buf.WriteString(messageLabel)
+	buf.WriteString(Separator + KeyMap.Message + AssignmentChar)
 
-		return buf.String()
-	}
-	itoaLevelMap := map[int]string{
-		LevelDebug: buildKV(LevelMap[LevelDebug]),
-		LevelWarn:  buildKV(LevelMap[LevelWarn]),
-		LevelInfo:  buildKV(LevelMap[LevelInfo]),
-		LevelError: buildKV(LevelMap[LevelError]),
-		LevelFatal: buildKV(LevelMap[LevelFatal]),
-	}
-	return &TextFormatter{itoaLevelMap: itoaLevelMap, name: name, timeLabel: timeLabel}
+	return buf.String()
 }
 
-func (tf *TextFormatter) set(buf bufferWriter, key string, val interface{}) {
+func (tf *TextFormatter) set(buf bufferWriter, key string, val interface{}, stack string) {
 	buf.WriteString(Separator)
 	buf.WriteString(key)
 	buf.WriteString(AssignmentChar)
 	if err, ok := val.(error); ok {
 		buf.WriteString(err.Error())
 		buf.WriteRune('\n')
-		buf.WriteString(string(debug.Stack()))
+		buf.WriteString(stack)
 		return
 	}
 	buf.WriteString(fmt.Sprintf("%v", val))
 }
 
+func (tf *TextFormatter) setFields(buf bufferWriter, fields []Field, stack string) {
+	for _, field := range fields {
+		tf.set(buf, field.Key, field.Value, stack)
+	}
+}
+
+// WithContext returns a new TextFormatter which writes the pre-encoded
+// key-value pairs args with every entry.
+func (tf *TextFormatter) WithContext(args []interface{}) Formatter {
+	buf := pool.Get()
+	defer pool.Put(buf)
+	buf.WriteString(tf.context)
+	fields := argsToFields(args)
+	var stack string
+	if hasError(fields) {
+		stack = string(debug.Stack())
+	}
+	tf.setFields(buf, fields, stack)
+	return &TextFormatter{
+		name:         tf.name,
+		itoaLevelMap: tf.itoaLevelMap,
+		timeLabel:    tf.timeLabel,
+		context:      buf.String(),
+	}
+}
+
+// WithName returns a new TextFormatter for name.
+func (tf *TextFormatter) WithName(name string) Formatter {
+	formatter := NewTextFormatter(name)
+	formatter.context = tf.context
+	return formatter
+}
+
 // Format records a log entry.
-func (tf *TextFormatter) Format(writer io.Writer, level int, msg string, args []interface{}) {
+func (tf *TextFormatter) Format(writer io.Writer, level Level, msg string, args []interface{}) {
+	tf.FormatEntry(writer, NewEntry(level, tf.name, msg, args))
+}
+
+// FormatEntry records entry.
+func (tf *TextFormatter) FormatEntry(writer io.Writer, entry *Entry) {
 	buf := pool.Get()
 	defer pool.Put(buf)
 	buf.WriteString(tf.timeLabel)
-	buf.WriteString(time.Now().Format(timeFormat))
-	buf.WriteString(tf.itoaLevelMap[level])
-	buf.WriteString(msg)
-	var lenArgs = len(args)
-	if lenArgs > 0 {
-		if lenArgs == 1 {
-			tf.set(buf, singleArgKey, args[0])
-		} else if lenArgs%2 == 0 {
-			for i := 0; i < lenArgs; i += 2 {
-				if key, ok := args[i].(string); ok {
-					if key == "" {
-						// show key is invalid
-						tf.set(buf, badKeyAtIndex(i), args[i+1])
-					} else {
-						tf.set(buf, key, args[i+1])
-					}
-				} else {
-					// show key is invalid
-					tf.set(buf, badKeyAtIndex(i), args[i+1])
-				}
-			}
-		} else {
-			tf.set(buf, warnImbalancedKey, args)
-		}
+	buf.WriteString(entry.Time.Format(settings().timeFormat))
+	if kv, ok := tf.itoaLevelMap[entry.Level]; ok {
+		buf.WriteString(kv)
+	} else {
+		// level registered after this formatter was created
+		buf.WriteString(tf.buildKV(entry.Level.String()))
 	}
+	buf.WriteString(entry.Message)
+	buf.WriteString(tf.context)
+	tf.setFields(buf, entry.Fields, entry.Stack)
 	buf.WriteRune('\n')
 	buf.WriteTo(writer)
 }